@@ -0,0 +1,154 @@
+package expire
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// What happens to a target when its record expires, see RunExpired
+const (
+	ActionNone   = "none"   // nothing, the record stays for next --expired to find
+	ActionDelete = "delete" // the target is removed
+	ActionTrash  = "trash"  // the target is moved to the trash, see trashTarget
+	// the target is packed into a .tar.gz, or with "archive:zst" a .tar.zst,
+	// and removed, see archiveTarget
+	ActionArchive = "archive"
+)
+
+// Runs a shell command, e.g. "exec:notify-send expired"
+const actionExecPrefix = "exec:"
+
+// The exit status an exec action uses to ask for the record to be renewed
+// rather than deleted (EX_TEMPFAIL)
+const actionRenewExitCode = 75
+
+func checkAction(action string) error {
+	switch {
+	case action == "", action == ActionNone, action == ActionDelete, action == ActionTrash:
+		return nil
+	case action == ActionArchive:
+		return nil
+	case strings.HasPrefix(action, ActionArchive+":"):
+		compression := strings.TrimPrefix(action, ActionArchive+":")
+		if compression != CompressionGzip && compression != CompressionZstd {
+			return fmt.Errorf("Invalid action: %s. Archives are %s or %s", action, CompressionGzip, CompressionZstd)
+		}
+		if compression == CompressionZstd {
+			_, err := exec.LookPath("zstd")
+			if err != nil {
+				return fmt.Errorf("Invalid action: %s. The zstd command was not found", action)
+			}
+		}
+		return nil
+	case strings.HasPrefix(action, actionExecPrefix):
+		if strings.TrimSpace(strings.TrimPrefix(action, actionExecPrefix)) == "" {
+			return fmt.Errorf("Invalid action: %s. No command", action)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown action: %s. Should be %s, %s, %s, %s[:%s] or %s<command>", action, ActionNone, ActionDelete, ActionTrash, ActionArchive, CompressionZstd, actionExecPrefix)
+}
+
+// The action of the record, defaulting to none
+func (r ExpirationRecord) GetAction() string {
+	if r.Action == "" {
+		return ActionNone
+	}
+	return r.Action
+}
+
+// What became of a record after its action ran
+type ActionOutcome int
+
+const (
+	ActionDone     ActionOutcome = 0 // the action succeeded and the record was deleted
+	ActionRenewed  ActionOutcome = 1 // the action asked for the record to be renewed
+	ActionFailed   ActionOutcome = 2 // the action failed and the record was kept as it was
+	ActionNotified ActionOutcome = 3 // the record has no action, but the daemon ran the on-expired hooks
+)
+
+func (o ActionOutcome) String() string {
+	switch o {
+	case ActionDone:
+		return "done"
+	case ActionRenewed:
+		return "renewed"
+	case ActionFailed:
+		return "failed"
+	case ActionNotified:
+		return "notified"
+	}
+	return "unknown"
+}
+
+// Runs the action of an expired record. The record must have been resolved
+// against the repo, see resolveTarget.
+func runAction(config GlobalConfig, rec *ExpirationRecord, expirationsPath string) (ActionOutcome, error) {
+	action := rec.GetAction()
+	switch {
+	case action == ActionDelete:
+		if rec.IsKey() {
+			return ActionDone, nil
+		}
+		var err error
+		if rec.GetKind() == KindDir {
+			err = os.RemoveAll(rec.targetFilePathAbs)
+		} else {
+			err = os.Remove(rec.targetFilePathAbs)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return ActionFailed, err
+		}
+		return ActionDone, nil
+	case action == ActionTrash:
+		err := trashTarget(rec, expirationsPath)
+		if err != nil {
+			return ActionFailed, err
+		}
+		return ActionDone, nil
+	case action == ActionArchive || strings.HasPrefix(action, ActionArchive+":"):
+		compression := CompressionGzip
+		if action != ActionArchive {
+			compression = strings.TrimPrefix(action, ActionArchive+":")
+		}
+		_, err := archiveTarget(config, rec, expirationsPath, compression)
+		if err != nil {
+			return ActionFailed, err
+		}
+		return ActionDone, nil
+	case strings.HasPrefix(action, actionExecPrefix):
+		return runExecAction(rec, expirationsPath, strings.TrimPrefix(action, actionExecPrefix))
+	}
+	return ActionFailed, fmt.Errorf("Unsupported action: %s", action)
+}
+
+// Runs the command with sh in the directory of the expirations file, with
+// the record in EXPIRE_TARGET, EXPIRE_PATH and EXPIRE_EXPIRES
+func runExecAction(rec *ExpirationRecord, expirationsPath string, command string) (ActionOutcome, error) {
+	dir, err := filepath.Abs(filepath.Dir(expirationsPath))
+	if err != nil {
+		return ActionFailed, err
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"EXPIRE_TARGET="+rec.Target,
+		"EXPIRE_PATH="+rec.targetFilePathAbs,
+		"EXPIRE_EXPIRES="+rec.Expires.Format(dateTimeFormat),
+	)
+
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == actionRenewExitCode {
+		return ActionRenewed, nil
+	}
+	if err != nil {
+		return ActionFailed, fmt.Errorf("Action for %s failed: %s", rec.Target, err)
+	}
+	return ActionDone, nil
+}
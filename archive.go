@@ -0,0 +1,512 @@
+package expire
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archives are tarballs named after the target and the time it was archived,
+// e.g. logs/app.20260102T150405.tar.gz in the archive directory (see
+// GlobalConfig.ArchiveDir). The first entry is the metadata, see
+// archiveMetadata. The rest is the target under its path relative to the
+// directory containing the expirations file, or its absolute path without
+// the leading / if it is outside of that directory.
+//
+// Each archive is also listed in an index next to the expirations file (see
+// getArchiveIndexPath), so that it can be restored after the archive
+// directory has been changed.
+
+const (
+	CompressionGzip = "gz"  // written and read natively
+	CompressionZstd = "zst" // written and read by the zstd command
+)
+
+const archiveMetadataName = ".expire-archive.json"
+const archiveTimeFormat = "20060102T150405"
+
+type archiveMetadata struct {
+	Target      string      `json:"target"`
+	Expirations string      `json:"expirations"` // the absolute path of the expirations file
+	Archived    string      `json:"archived"`
+	Record      jsonlRecord `json:"record"`
+}
+
+// An entry in the archive index, one JSON object per line
+type archiveIndexEntry struct {
+	Target   string `json:"target"`
+	Archive  string `json:"archive"` // the absolute path of the archive
+	Archived string `json:"archived"`
+}
+
+func getArchiveIndexPath(expirationsPath string) string {
+	return expirationsPath + ".archives"
+}
+
+// Appends an entry to the archive index. Each entry is a single write to a
+// file opened for appending, so concurrent writers don't interleave.
+func addArchiveIndexEntry(expirationsPath string, entry archiveIndexEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(getArchiveIndexPath(expirationsPath), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// The archives listed in the index for the target. Entries whose archive is
+// gone are skipped.
+func readArchiveIndex(expirationsPath string, target string) ([]string, error) {
+	f, err := os.Open(getArchiveIndexPath(expirationsPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	archives := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry archiveIndexEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil || entry.Target != target || !exists(entry.Archive) {
+			continue
+		}
+		archives = append(archives, entry.Archive)
+	}
+	return archives, scanner.Err()
+}
+
+func archiveExt(compression string) string {
+	return ".tar." + compression
+}
+
+// The name of the target within the archive
+func archiveEntryName(target string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(target)), "/")
+}
+
+// Where the archive entries of the target are extracted to: the directory
+// containing the expirations file, or / for absolute targets
+func archiveRoot(expirationsPath string, target string) (string, error) {
+	if filepath.IsAbs(target) {
+		return "/", nil
+	}
+	return filepath.Abs(filepath.Dir(expirationsPath))
+}
+
+// Packs the target of the record into an archive in the archive directory,
+// then removes it. Returns the path of the archive, or "" if there was
+// nothing to archive.
+// The record must have been resolved against the repo, see resolveTarget.
+func archiveTarget(config GlobalConfig, rec *ExpirationRecord, expirationsPath string, compression string) (string, error) {
+	if rec.IsKey() || !exists(rec.targetFilePathAbs) {
+		return "", nil
+	}
+
+	archiveDir, err := config.getArchiveDir(expirationsPath)
+	if err != nil {
+		return "", err
+	}
+	absExpirationsPath, err := filepath.Abs(expirationsPath)
+	if err != nil {
+		return "", err
+	}
+	root, err := archiveRoot(expirationsPath, rec.Target)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	entryName := archiveEntryName(rec.Target)
+	prefix := filepath.Join(archiveDir, filepath.FromSlash(entryName)) + "." + now.Format(archiveTimeFormat)
+	err = os.MkdirAll(filepath.Dir(prefix), 0777)
+	if err != nil {
+		return "", err
+	}
+
+	var f *os.File
+	var archivePath string
+	for i := 1; ; i++ {
+		archivePath = prefix + archiveExt(compression)
+		if i > 1 {
+			archivePath = fmt.Sprintf("%s.%d%s", prefix, i, archiveExt(compression))
+		}
+		f, err = os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	metadata := archiveMetadata{
+		Target:      rec.Target,
+		Expirations: absExpirationsPath,
+		Archived:    now.Format(dateTimeFormat),
+		Record:      toJSONLRecord(*rec),
+	}
+	err = writeArchive(f, compression, func(tw *tar.Writer) error {
+		return writeArchiveEntries(tw, metadata, root, entryName)
+	})
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return "", fmt.Errorf("Failed to archive %s: %s", rec.targetFilePathAbs, err)
+	}
+
+	err = addArchiveIndexEntry(expirationsPath, archiveIndexEntry{
+		Target:   rec.Target,
+		Archive:  archivePath,
+		Archived: metadata.Archived,
+	})
+	if err != nil {
+		os.Remove(archivePath)
+		return "", fmt.Errorf("Failed to index the archive of %s: %s", rec.targetFilePathAbs, err)
+	}
+
+	return archivePath, os.RemoveAll(rec.targetFilePathAbs)
+}
+
+func writeArchive(f *os.File, compression string, write func(*tar.Writer) error) error {
+	switch compression {
+	case CompressionGzip:
+		zw := gzip.NewWriter(f)
+		tw := tar.NewWriter(zw)
+		err := write(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		return err
+	case CompressionZstd:
+		cmd := exec.Command("zstd", "-q", "-c")
+		cmd.Stdout = f
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		err = cmd.Start()
+		if err != nil {
+			return err
+		}
+		tw := tar.NewWriter(stdin)
+		err = write(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		stdin.Close()
+		waitErr := cmd.Wait()
+		if err == nil {
+			err = waitErr
+		}
+		return err
+	}
+	return fmt.Errorf("Unknown compression: %s", compression)
+}
+
+func writeArchiveEntries(tw *tar.Writer, metadata archiveMetadata, root string, entryName string) error {
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    archiveMetadataName,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(filepath.Join(root, filepath.FromSlash(entryName)), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// Opens the archive for reading, decompressing according to its extension
+func openArchive(archivePath string) (io.ReadCloser, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(archivePath, archiveExt(CompressionGzip)):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &archiveReader{zr, func() error {
+			zr.Close()
+			return f.Close()
+		}}, nil
+	case strings.HasSuffix(archivePath, archiveExt(CompressionZstd)):
+		cmd := exec.Command("zstd", "-q", "-d", "-c")
+		cmd.Stdin = f
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		err = cmd.Start()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &archiveReader{stdout, func() error {
+			// Drain it so zstd isn't killed by a closed pipe
+			io.Copy(ioutil.Discard, stdout)
+			err := cmd.Wait()
+			f.Close()
+			return err
+		}}, nil
+	}
+	f.Close()
+	return nil, fmt.Errorf("Unknown archive type: %s", archivePath)
+}
+
+type archiveReader struct {
+	io.Reader
+	close func() error
+}
+
+func (r *archiveReader) Close() error {
+	return r.close()
+}
+
+// Reads the metadata entry at the start of the archive
+func readArchiveMetadata(archivePath string) (archiveMetadata, error) {
+	var metadata archiveMetadata
+	r, err := openArchive(archivePath)
+	if err != nil {
+		return metadata, err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return metadata, err
+	}
+	if hdr.Name != archiveMetadataName {
+		return metadata, fmt.Errorf("Not an expire archive: %s", archivePath)
+	}
+	err = json.NewDecoder(tr).Decode(&metadata)
+	return metadata, err
+}
+
+// The archives of the target made from the repo, most recent first: the
+// ones in the index, and any others in the current archive directory
+func findArchives(config GlobalConfig, expirationsPath string, target string) ([]string, error) {
+	candidates, err := readArchiveIndex(expirationsPath, target)
+	if err != nil {
+		return nil, err
+	}
+	scanned, err := scanArchiveDir(config, expirationsPath, target)
+	if err != nil {
+		return nil, err
+	}
+	candidates = append(candidates, scanned...)
+
+	absExpirationsPath, err := filepath.Abs(expirationsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]string, 0, len(candidates))
+	archived := make(map[string]time.Time, len(candidates))
+	for _, archivePath := range candidates {
+		if _, ok := archived[archivePath]; ok {
+			continue
+		}
+		metadata, err := readArchiveMetadata(archivePath)
+		if err != nil || metadata.Target != target || metadata.Expirations != absExpirationsPath {
+			continue
+		}
+		archived[archivePath], _ = time.Parse(dateTimeFormat, metadata.Archived)
+		archives = append(archives, archivePath)
+	}
+	sort.SliceStable(archives, func(i, j int) bool {
+		ti, tj := archived[archives[i]], archived[archives[j]]
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return archives[i] > archives[j]
+	})
+	return archives, nil
+}
+
+// The files in the archive directory which look like archives of the target
+func scanArchiveDir(config GlobalConfig, expirationsPath string, target string) ([]string, error) {
+	archiveDir, err := config.getArchiveDir(expirationsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Join(archiveDir, filepath.FromSlash(archiveEntryName(target)))
+	infos, err := ioutil.ReadDir(filepath.Dir(prefix))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]string, 0)
+	for _, fi := range infos {
+		name := fi.Name()
+		if !strings.HasPrefix(name, filepath.Base(prefix)+".") {
+			continue
+		}
+		if !strings.HasSuffix(name, archiveExt(CompressionGzip)) && !strings.HasSuffix(name, archiveExt(CompressionZstd)) {
+			continue
+		}
+		archives = append(archives, filepath.Join(filepath.Dir(prefix), name))
+	}
+	return archives, nil
+}
+
+// Unpacks the target from the archive to where it was, and returns its
+// metadata. The target must not exist.
+func extractArchive(archivePath string, expirationsPath string) (archiveMetadata, error) {
+	var metadata archiveMetadata
+	r, err := openArchive(archivePath)
+	if err != nil {
+		return metadata, err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return metadata, err
+	}
+	if hdr.Name != archiveMetadataName {
+		return metadata, fmt.Errorf("Not an expire archive: %s", archivePath)
+	}
+	err = json.NewDecoder(tr).Decode(&metadata)
+	if err != nil {
+		return metadata, err
+	}
+
+	root, err := archiveRoot(expirationsPath, metadata.Target)
+	if err != nil {
+		return metadata, err
+	}
+	entryName := archiveEntryName(metadata.Target)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return metadata, nil
+		}
+		if err != nil {
+			return metadata, err
+		}
+
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if name != entryName && !strings.HasPrefix(name, entryName+"/") {
+			return metadata, fmt.Errorf("Unexpected entry in %s: %s", archivePath, hdr.Name)
+		}
+		p := filepath.Join(root, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(p), 0777)
+		if err != nil {
+			return metadata, err
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.Mkdir(p, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, p)
+		case tar.TypeReg:
+			var f *os.File
+			f, err = os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+			if err == nil {
+				_, err = io.Copy(f, tr)
+				closeErr := f.Close()
+				if err == nil {
+					err = closeErr
+				}
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return metadata, err
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			os.Chtimes(p, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
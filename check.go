@@ -9,7 +9,7 @@ type CheckConfig struct {
 }
 
 func checkCheck(config *CheckConfig) error {
-	if config.Target == "" {
+	if len(config.getTargets()) == 0 {
 		return errors.New("No target")
 	}
 	return nil
@@ -23,30 +23,115 @@ const (
 	Untracked                      = 2
 )
 
+func (r CheckResponse) String() string {
+	switch r {
+	case TrackedUnexpired:
+		return "unexpired"
+	case TrackedExpired:
+		return "expired"
+	case Untracked:
+		return "untracked"
+	}
+	return "unknown"
+}
+
+// The result of checking one of several targets
+type CheckResult struct {
+	Target   string
+	Response CheckResponse
+	Record   *ExpirationRecord // nil if the target is untracked
+}
+
 func Check(config *CheckConfig) (CheckResponse, error) {
-	checkCheck(config)
+	resp, _, err := CheckRecord(config)
+	return resp, err
+}
+
+// Like Check, but also returns the record, resolved against the repo.
+// The record is nil if the target is untracked.
+func CheckRecord(config *CheckConfig) (CheckResponse, *ExpirationRecord, error) {
+	err := checkCheck(config)
+	if err != nil {
+		return Untracked, nil, err
+	}
 
-	expirationsPath := getExpirationsFilePath(config.GlobalConfig)
-	if expirationsPath == "" {
-		return Untracked, nil
+	results, err := CheckTargets(&CheckConfig{
+		GlobalConfig: config.GlobalConfig,
+		TargetConfig: TargetConfig{
+			Target:          config.getTargets()[0],
+			Raw:             config.Raw,
+			ResolveSymlinks: config.ResolveSymlinks,
+		},
+	})
+	if err != nil {
+		return Untracked, nil, err
 	}
+	return results[0].Response, results[0].Record, nil
+}
 
-	records, err := readRecordsFromFile(expirationsPath)
+// Checks every target against a single read of the expirations file
+func CheckTargets(config *CheckConfig) ([]CheckResult, error) {
+	err := checkCheck(config)
 	if err != nil {
-		return Untracked, err
+		return nil, err
 	}
 
-	rec, ok := records.getFirst(func(rec ExpirationRecord) bool {
-		return rec.Target == config.Target
-	})
+	targets := config.getTargets()
+	results := make([]CheckResult, 0, len(targets))
+
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		for _, target := range targets {
+			results = append(results, CheckResult{Target: target, Response: Untracked})
+		}
+		return results, nil
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	canonical, err := config.canonicalTargets(store.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, target := range targets {
+		rec, ok := records.getFirst(func(rec ExpirationRecord) bool {
+			return rec.Target == canonical[i]
+		})
+
+		if !ok {
+			results = append(results, CheckResult{Target: target, Response: Untracked})
+			continue
+		}
+
+		if !rec.IsKey() {
+			rec.targetFilePathAbs, err = resolveTarget(store.Path(), rec.Target)
+			if err == nil {
+				rec.targetExists = exists(rec.targetFilePathAbs)
+			}
+		}
+
+		var resp CheckResponse = TrackedExpired
+		if rec.Expires.After(now) {
+			resp = TrackedUnexpired
+		}
+		results = append(results, CheckResult{Target: target, Response: resp, Record: &rec})
+	}
+	return results, nil
+}
 
-	if ok {
-		if rec.Expires.After(time.Now()) {
-			return TrackedUnexpired, nil
-		} else {
-			return TrackedExpired, nil
+// The exit status for checking several targets: the worst response,
+// i.e. untracked if any target is untracked, else expired if any has expired
+func AggregateCheckResponse(results []CheckResult) CheckResponse {
+	agg := TrackedUnexpired
+	for _, result := range results {
+		if result.Response > agg {
+			agg = result.Response
 		}
-	} else {
-		return Untracked, nil
 	}
+	return agg
 }
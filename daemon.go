@@ -0,0 +1,312 @@
+package expire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultDaemonPollInterval = 10 * time.Second
+
+// Failed actions are retried after the poll interval, doubling each time up
+// to the maximum backoff, until they have failed this many times
+const daemonMaxAttempts = 8
+const daemonMaxBackoff = time.Hour
+
+type DaemonConfig struct {
+	GlobalConfig
+	// The expirations files to watch, or directories containing them.
+	// Defaults to the current repo.
+	Repos []string
+	// The longest the daemon sleeps before checking the repos for changes
+	// and the wall clock for time lost to suspend (defaults to 10 seconds).
+	// Also how long it waits before first retrying a failed action.
+	PollInterval time.Duration
+}
+
+func (config DaemonConfig) getPollInterval() time.Duration {
+	if config.PollInterval == 0 {
+		return defaultDaemonPollInterval
+	}
+	return config.PollInterval
+}
+
+// A repo watched by the daemon
+type daemonRepo struct {
+	store    Store
+	modTime  time.Time
+	size     int64
+	records  ExpirationRecords
+	retryAt  time.Time                 // when to try again after the repo couldn't be processed
+	failures map[string]*daemonFailure // by recordKey
+}
+
+// The failed attempts at the action, or notification, of a record
+type daemonFailure struct {
+	attempts int
+	retryAt  time.Time
+}
+
+// Identifies a record until it is renewed
+func recordKey(rec ExpirationRecord) string {
+	return fmt.Sprintf("%s\x00%d\x00%s", rec.Target, rec.Seq, rec.Expires.Format(time.RFC3339Nano))
+}
+
+// Whether the record failed too recently, or too often, to be tried now
+func (repo *daemonRepo) backingOff(rec ExpirationRecord, now time.Time) bool {
+	failure, ok := repo.failures[recordKey(rec)]
+	return ok && (failure.attempts >= daemonMaxAttempts || now.Before(failure.retryAt))
+}
+
+func (repo *daemonRepo) recordResult(result ActionResult, now time.Time, pollInterval time.Duration) {
+	key := recordKey(*result.Record)
+	if result.Outcome != ActionFailed {
+		delete(repo.failures, key)
+		return
+	}
+
+	failure, ok := repo.failures[key]
+	if !ok {
+		failure = &daemonFailure{}
+		repo.failures[key] = failure
+	}
+	failure.attempts++
+	backoff := pollInterval
+	for i := 1; i < failure.attempts && backoff < daemonMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > daemonMaxBackoff {
+		backoff = daemonMaxBackoff
+	}
+	failure.retryAt = now.Add(backoff)
+	if failure.attempts >= daemonMaxAttempts {
+		log.Printf("Giving up on %s after %d failed attempts, until it changes", result.Record.Target, failure.attempts)
+	}
+}
+
+// Loads the records again if the file has changed since they were loaded
+func (repo *daemonRepo) reload() error {
+	info, err := os.Stat(repo.store.Path())
+	if err == nil && repo.records != nil && info.ModTime().Equal(repo.modTime) && info.Size() == repo.size {
+		return nil
+	}
+	records, err := repo.store.Load()
+	if err != nil {
+		return err
+	}
+	repo.records = records
+	if info != nil {
+		repo.modTime = info.ModTime()
+		repo.size = info.Size()
+	}
+
+	// Forget the failures of records which were renewed or removed
+	keys := make(map[string]bool, len(records))
+	for _, rec := range records {
+		keys[recordKey(*rec)] = true
+	}
+	for key := range repo.failures {
+		if !keys[key] {
+			delete(repo.failures, key)
+		}
+	}
+	return nil
+}
+
+// When the next action or notification is due, if any
+func (repo *daemonRepo) nextDue(notify bool) (time.Time, bool) {
+	shadowed := repo.records.shadowed()
+	var due time.Time
+	found := false
+	for _, rec := range repo.records {
+		if shadowed[rec] || rec.isClaimed() {
+			continue
+		}
+		if rec.GetAction() == ActionNone && (!notify || rec.isNotified()) {
+			continue
+		}
+		recDue := rec.Expires
+		if failure, ok := repo.failures[recordKey(*rec)]; ok {
+			if failure.attempts >= daemonMaxAttempts {
+				continue
+			}
+			if failure.retryAt.After(recDue) {
+				recDue = failure.retryAt
+			}
+		}
+		if !found || recDue.Before(due) {
+			due = recDue
+			found = true
+		}
+	}
+	if found && repo.retryAt.After(due) {
+		due = repo.retryAt
+	}
+	return due, found
+}
+
+func findDaemonRepos(config DaemonConfig) ([]*daemonRepo, error) {
+	if config.Store != nil {
+		return []*daemonRepo{newDaemonRepo(config.Store)}, nil
+	}
+
+	repos := make([]*daemonRepo, 0, len(config.Repos))
+	for _, repoPath := range config.Repos {
+		info, err := os.Stat(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			repoPath = filepath.Join(repoPath, config.getFileName())
+		}
+		repos = append(repos, newDaemonRepo(newFileStore(repoPath, config.GlobalConfig)))
+	}
+	if len(repos) == 0 {
+		store := findStore(config.GlobalConfig)
+		if store == nil {
+			return nil, errors.New("No expirations file")
+		}
+		repos = append(repos, newDaemonRepo(store))
+	}
+	return repos, nil
+}
+
+func newDaemonRepo(store Store) *daemonRepo {
+	return &daemonRepo{
+		store:    store,
+		failures: make(map[string]*daemonFailure),
+	}
+}
+
+// Whether the on-expired hooks ran for the record since it last expired
+func (r ExpirationRecord) isNotified() bool {
+	return !r.Notified.IsZero() && !r.Notified.Before(r.Expires)
+}
+
+// Runs the on-expired hooks for the expired records without an action which
+// haven't been notified since they expired, and marks them notified.
+// Records are only reported and marked if there was a hook to run.
+func notifyExpired(config GlobalConfig, skip func(ExpirationRecord) bool) ([]ActionResult, error) {
+	store := findStore(config)
+	records, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	shadowed := records.shadowed()
+	now := time.Now()
+	results := make([]ActionResult, 0)
+	for _, rec := range records {
+		if shadowed[rec] || rec.GetAction() != ActionNone || rec.Expires.After(now) || rec.isNotified() || skip(*rec) {
+			continue
+		}
+		if !rec.IsKey() {
+			rec.targetFilePathAbs, err = resolveTarget(store.Path(), rec.Target)
+			if err != nil {
+				results = append(results, ActionResult{rec, ActionFailed, err})
+				continue
+			}
+		}
+		ran, err := runHooks(config, HookOnExpired, *rec, store.Path())
+		if err != nil {
+			results = append(results, ActionResult{rec, ActionFailed, err})
+			continue
+		}
+		if ran {
+			results = append(results, ActionResult{rec, ActionNotified, nil})
+		}
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		write := false
+		for _, result := range results {
+			if result.Outcome != ActionNotified {
+				continue
+			}
+			rec := result.Record
+			write = records.updateFirst(func(r ExpirationRecord) bool {
+				return r.Target == rec.Target && r.Seq == rec.Seq && r.Expires.Equal(rec.Expires)
+			}, func(r *ExpirationRecord) {
+				r.Notified = now
+			}) || write
+		}
+		return write, nil
+	})
+	return results, err
+}
+
+// Runs the actions of expired records as they expire, see RunExpired, until
+// the context is cancelled. Expired records without an action have the
+// on-expired hooks run for them instead, once per expiry. It sleeps until
+// the soonest expiry across the repos, but never longer than the poll
+// interval, so that changes to the repos are picked up and time spent
+// suspended is noticed. Failed actions and hooks are retried with backoff,
+// per record, until they have failed daemonMaxAttempts times.
+// Each result is passed to report.
+func Daemon(ctx context.Context, config *DaemonConfig, report func(ActionResult)) error {
+	repos, err := findDaemonRepos(*config)
+	if err != nil {
+		return err
+	}
+
+	for {
+		now := time.Now()
+		wake := now.Add(config.getPollInterval())
+		for _, repo := range repos {
+			err := repo.reload()
+			if err != nil {
+				log.Printf("Failed to load %s: %s", repo.store.Path(), err.Error())
+				continue
+			}
+
+			notify := len(findHooks(config.GlobalConfig, HookOnExpired, repo.store.Path())) > 0
+			due, ok := repo.nextDue(notify)
+			if !ok {
+				continue
+			}
+			if due.After(now) {
+				if due.Before(wake) {
+					wake = due
+				}
+				continue
+			}
+
+			global := config.GlobalConfig
+			global.Store = repo.store
+			skip := func(rec ExpirationRecord) bool {
+				return repo.backingOff(rec, now)
+			}
+			results, err := RunExpired(&RunExpiredConfig{GlobalConfig: global, skip: skip})
+			if err == nil && notify {
+				var notified []ActionResult
+				notified, err = notifyExpired(global, skip)
+				results = append(results, notified...)
+			}
+			repo.retryAt = time.Time{}
+			if err != nil {
+				log.Printf("Failed to run expired actions in %s: %s", repo.store.Path(), err.Error())
+				repo.retryAt = now.Add(config.getPollInterval())
+			}
+			for _, result := range results {
+				report(result)
+				repo.recordResult(result, now, config.getPollInterval())
+			}
+			repo.records = nil
+			// Check again right away, for records which expired meanwhile
+			wake = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package expire
+
+import (
+	"errors"
+)
+
+type DedupeConfig struct {
+	GlobalConfig
+	DryRunConfig
+}
+
+// Deletes the records shadowed by a more recent record for the same target.
+// Returns the number of records deleted.
+func Dedupe(config *DedupeConfig) (int, error) {
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		return 0, errors.New("No expirations file")
+	}
+
+	deleted := 0
+	err := store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		shadowed := records.shadowed()
+		if config.IsDryRun {
+			for _, rec := range *records {
+				if shadowed[rec] {
+					dryRunReporter.ReportAction("Will delete shadowed record: %s (expires %s)", rec.Target, rec.Expires.Format(dateTimeFormat))
+				}
+			}
+			deleted = len(shadowed)
+			return false, nil
+		}
+
+		kept := make(ExpirationRecords, 0, len(*records))
+		for _, rec := range *records {
+			if !shadowed[rec] {
+				kept = append(kept, rec)
+			}
+		}
+		deleted = len(shadowed)
+		*records = kept
+		return deleted > 0, nil
+	})
+	return deleted, err
+}
@@ -1,7 +1,6 @@
 package expire
 
 import "errors"
-import "os"
 
 type DeleteConfig struct {
 	GlobalConfig
@@ -12,17 +11,20 @@ type DeleteConfig struct {
 }
 
 func checkDelete(config *DeleteConfig) error {
-	if config.Target == "" {
+	if len(config.getTargets()) == 0 {
 		return errors.New("No target")
 	}
 	return nil
 }
 
 func Delete(config *DeleteConfig) error {
-	checkDelete(config)
+	err := checkDelete(config)
+	if err != nil {
+		return err
+	}
 
-	expirationsPath := getExpirationsFilePath(config.GlobalConfig)
-	if expirationsPath == "" {
+	store := findStore(config.GlobalConfig)
+	if store == nil {
 		if config.IsBatchRun {
 			return nil
 		} else {
@@ -30,37 +32,65 @@ func Delete(config *DeleteConfig) error {
 		}
 	}
 
-	records, err := readRecordsFromFile(expirationsPath)
+	targets, err := config.canonicalTargets(store.Path())
 	if err != nil {
 		return err
 	}
 
-	_, present := records.deleteFirst(func(rec ExpirationRecord) bool {
-		return rec.Target == config.Target
-	})
+	var errs []error
+	var deleted []ExpirationRecord
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		for _, target := range targets {
+			isTarget := func(rec ExpirationRecord) bool {
+				return rec.Target == target
+			}
+			rec, present := records.getFirst(isTarget)
 
-	if !present {
-		if config.IsDryRun {
-			dryRunReporter.ReportAction("Will not delete non-existent record: %s", config.Target)
+			if !present {
+				if config.IsDryRun {
+					dryRunReporter.ReportAction("Will not delete non-existent record: %s", target)
+				}
+				if !config.IsBatchRun {
+					errs = append(errs, errors.New("No such record: "+target))
+				}
+				continue
+			}
+
+			if config.IsDryRun {
+				dryRunReporter.ReportAction("Will delete record: %s", target)
+			} else {
+				_, err := runHooks(config.GlobalConfig, HookPreDelete, rec, store.Path())
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			records.deleteFirst(isTarget)
+			deleted = append(deleted, rec)
 		}
-		if config.IsBatchRun {
-			return nil
-		} else {
-			return errors.New("No such record: " + config.Target)
+
+		if len(deleted) == 0 {
+			return false, nil
 		}
-	}
 
-	if config.IsDryRun {
-		dryRunReporter.ReportAction("Will delete record: %s", config.Target)
-		if len(records) == 0 && config.DeInit {
-			dryRunReporter.ReportAction("Will delete the file: %s", expirationsPath)
+		if config.IsDryRun {
+			if len(*records) == 0 && config.DeInit {
+				dryRunReporter.ReportAction("Will delete the file: %s", store.Path())
+			}
+			return false, nil
 		}
-		return nil
-	}
 
-	if len(records) == 0 && config.DeInit {
-		return os.Remove(expirationsPath)
-	} else {
-		return writeRecordsToFile(expirationsPath, records)
+		if len(*records) == 0 && config.DeInit {
+			return false, store.Remove()
+		} else {
+			return true, nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !config.IsDryRun {
+		runPostHooks(config.GlobalConfig, HookPostDelete, deleted, store.Path())
 	}
+	return targetErrors(errs)
 }
@@ -11,7 +11,7 @@ type DryRunReporter interface {
 type ConsoleDryRunReporter struct{}
 
 func (r ConsoleDryRunReporter) ReportAction(formatString string, arg ...interface{}) {
-	fmt.Printf(formatString+"\n", arg)
+	fmt.Printf(formatString+"\n", arg...)
 }
 
 var dryRunReporter = ConsoleDryRunReporter{}
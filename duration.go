@@ -0,0 +1,271 @@
+package expire
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Months and years don't have a fixed length, these are approximations
+var durationUnits = map[string]time.Duration{
+	"s":       time.Second,
+	"sec":     time.Second,
+	"secs":    time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"m":       time.Minute,
+	"min":     time.Minute,
+	"mins":    time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hr":      time.Hour,
+	"hrs":     time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"d":       day,
+	"day":     day,
+	"days":    day,
+	"w":       7 * day,
+	"wk":      7 * day,
+	"wks":     7 * day,
+	"week":    7 * day,
+	"weeks":   7 * day,
+	"mo":      30 * day,
+	"month":   30 * day,
+	"months":  30 * day,
+	"y":       365 * day,
+	"yr":      365 * day,
+	"yrs":     365 * day,
+	"year":    365 * day,
+	"years":   365 * day,
+}
+
+// A number (or "a"/"an") followed by a unit, e.g. "15 minutes", "2w", "an hour"
+var durationTermRegex = regexp.MustCompile(`(\d+(?:\.\d+)?|\ban?\b)\s*([a-z]+)`)
+var durationSeparatorRegex = regexp.MustCompile(`\s*(?:,|\band\b)\s*|\s+`)
+
+// Parses durations written for humans, e.g. "15 minutes", "1 day", "2w",
+// "1 week and 2 days", as well as anything time.ParseDuration accepts.
+// A month is 30 days and a year is 365 days.
+func ParseDurationString(str string) (time.Duration, error) {
+	d, err := time.ParseDuration(str)
+	if err == nil {
+		return d, nil
+	}
+
+	var total time.Duration
+	err = parseDurationTerms(str, func(amount float64, unitName string) error {
+		unit, ok := durationUnits[unitName]
+		if !ok {
+			return fmt.Errorf("Invalid duration: %q. Unknown unit %q", str, unitName)
+		}
+		total += time.Duration(amount * float64(unit))
+		return nil
+	})
+	return total, err
+}
+
+// Splits a duration into terms of an amount and a unit, separated by
+// whitespace, commas or "and"
+func parseDurationTerms(str string, term func(amount float64, unit string) error) error {
+	normalized := strings.ToLower(strings.TrimSpace(str))
+	if normalized == "" {
+		return fmt.Errorf("Invalid duration: %q", str)
+	}
+
+	terms := durationTermRegex.FindAllStringSubmatchIndex(normalized, -1)
+	if len(terms) == 0 {
+		return fmt.Errorf("Invalid duration: %q", str)
+	}
+
+	last := 0
+	for _, idx := range terms {
+		// Only separators are allowed between terms
+		if durationSeparatorRegex.ReplaceAllString(normalized[last:idx[0]], "") != "" {
+			return fmt.Errorf("Invalid duration: %q", str)
+		}
+		last = idx[1]
+
+		number := normalized[idx[2]:idx[3]]
+		amount := 1.0
+		if number != "a" && number != "an" {
+			var err error
+			amount, err = strconv.ParseFloat(number, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid duration: %q", str)
+			}
+		}
+		err := term(amount, normalized[idx[4]:idx[5]])
+		if err != nil {
+			return err
+		}
+	}
+	if durationSeparatorRegex.ReplaceAllString(normalized[last:], "") != "" {
+		return fmt.Errorf("Invalid duration: %q", str)
+	}
+	return nil
+}
+
+var deadlineLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+var clockRegex = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?\s*(am|pm)?$`)
+
+// Parses an absolute point in time, relative to now:
+// RFC3339 or a date ("2026-12-31", "2026-12-31 17:00"), or a phrase like
+// "tomorrow", "tomorrow 9am", "next monday", "friday 17:00", "noon",
+// "in 2 days". A day without a time of day means midnight at its start.
+func ParseDeadline(str string, now time.Time) (time.Time, error) {
+	trimmed := strings.TrimSpace(str)
+	for _, layout := range deadlineLayouts {
+		var t time.Time
+		var err error
+		if layout == time.RFC3339 {
+			t, err = time.Parse(layout, trimmed)
+		} else {
+			t, err = time.ParseInLocation(layout, trimmed, now.Location())
+		}
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	normalized := strings.ToLower(trimmed)
+	if strings.HasPrefix(normalized, "in ") {
+		d, err := ParseDurationString(strings.TrimPrefix(normalized, "in "))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid deadline: %q", str)
+		}
+		return now.Add(d), nil
+	}
+	if normalized == "now" {
+		return now, nil
+	}
+
+	words := strings.Fields(normalized)
+	if len(words) == 0 {
+		return time.Time{}, fmt.Errorf("Invalid deadline: %q", str)
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	date := midnight
+	explicitDay := true
+	switch {
+	case words[0] == "today":
+		words = words[1:]
+	case words[0] == "tomorrow":
+		date = midnight.AddDate(0, 0, 1)
+		words = words[1:]
+	case words[0] == "next" && len(words) > 1:
+		if wd, ok := weekdays[words[1]]; ok {
+			date = nextWeekday(midnight, wd)
+			words = words[2:]
+		} else {
+			return time.Time{}, fmt.Errorf("Invalid deadline: %q", str)
+		}
+	default:
+		if wd, ok := weekdays[words[0]]; ok {
+			date = nextWeekday(midnight, wd)
+			words = words[1:]
+		} else {
+			explicitDay = false
+		}
+	}
+
+	if len(words) == 0 {
+		return date, nil
+	}
+
+	clock, err := parseClock(strings.Join(words, " "))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid deadline: %q", str)
+	}
+	t := date.Add(clock)
+	if !explicitDay && !t.After(now) {
+		// A bare time of day means the next time it comes around
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Like ParseDeadline, but the deadline must be after now
+func parseFutureDeadline(str string, now time.Time) (time.Time, error) {
+	deadline, err := ParseDeadline(str, now)
+	if err != nil {
+		return deadline, err
+	}
+	if !deadline.After(now) {
+		return deadline, fmt.Errorf("Deadline %q has already passed: %s", str, deadline.Format(dateTimeFormat))
+	}
+	return deadline, nil
+}
+
+// The first day strictly after from which falls on the weekday
+func nextWeekday(from time.Time, wd time.Weekday) time.Time {
+	days := (int(wd) - int(from.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+	return from.AddDate(0, 0, days)
+}
+
+// Parses a time of day, e.g. "9am", "9:30 pm", "17:00", "noon", "midnight"
+// as an offset from midnight
+func parseClock(str string) (time.Duration, error) {
+	switch str {
+	case "noon":
+		return 12 * time.Hour, nil
+	case "midnight":
+		return 0, nil
+	}
+	m := clockRegex.FindStringSubmatch(str)
+	if m == nil {
+		return 0, fmt.Errorf("Invalid time of day: %q", str)
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	switch m[3] {
+	case "am":
+		if hour < 1 || hour > 12 {
+			return 0, fmt.Errorf("Invalid time of day: %q", str)
+		}
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 1 || hour > 12 {
+			return 0, fmt.Errorf("Invalid time of day: %q", str)
+		}
+		if hour != 12 {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, fmt.Errorf("Invalid time of day: %q", str)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, nil
+}
@@ -0,0 +1,119 @@
+package expire
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDurationString(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{str: "10m", want: 10 * time.Minute},
+		{str: "1h30m", want: 90 * time.Minute},
+		{str: "-5m", want: -5 * time.Minute},
+		{str: "15 minutes", want: 15 * time.Minute},
+		{str: "an hour", want: time.Hour},
+		{str: "a day", want: day},
+		{str: "2w", want: 14 * day},
+		{str: "1 week and 2 days", want: 9 * day},
+		{str: "1 day, 2 hours", want: 26 * time.Hour},
+		{str: "1.5 hours", want: 90 * time.Minute},
+		{str: "1 month", want: 30 * day},
+		{str: "1 YEAR", want: 365 * day},
+		{str: "0 days", want: 0},
+		{str: "", wantErr: true},
+		{str: "soon", wantErr: true},
+		{str: "5 fortnights", wantErr: true},
+		{str: "1 day or 2", wantErr: true},
+		{str: "1 day extra", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseDurationString(test.str)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseDurationString(%q) = %s, expected an error", test.str, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDurationString(%q) failed: %s", test.str, err)
+		} else if got != test.want {
+			t.Errorf("ParseDurationString(%q) = %s, expected %s", test.str, got, test.want)
+		}
+	}
+}
+
+func TestParseDeadline(t *testing.T) {
+	// A wednesday
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+	at := func(month time.Month, d int, hour int, minute int) time.Time {
+		return time.Date(2026, month, d, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		str     string
+		want    time.Time
+		wantErr bool
+	}{
+		{str: "2026-12-31", want: at(12, 31, 0, 0)},
+		{str: "2026-12-31 17:00", want: at(12, 31, 17, 0)},
+		{str: "2026-12-31T17:00:05", want: at(12, 31, 17, 0).Add(5 * time.Second)},
+		{str: "2026-12-31T17:00:00+02:00", want: at(12, 31, 15, 0)},
+		{str: "now", want: now},
+		{str: "in 2 days", want: now.Add(2 * day)},
+		{str: "in -5m", want: now.Add(-5 * time.Minute)},
+		{str: "today", want: at(10, 14, 0, 0)},
+		{str: "today 5pm", want: at(10, 14, 17, 0)},
+		{str: "tomorrow", want: at(10, 15, 0, 0)},
+		{str: "Tomorrow 9am", want: at(10, 15, 9, 0)},
+		{str: "tomorrow 12am", want: at(10, 15, 0, 0)},
+		{str: "friday", want: at(10, 16, 0, 0)},
+		{str: "friday 17:00", want: at(10, 16, 17, 0)},
+		{str: "wednesday", want: at(10, 21, 0, 0)},
+		{str: "next monday", want: at(10, 19, 0, 0)},
+		{str: "noon", want: at(10, 14, 12, 0)},
+		{str: "9am", want: at(10, 15, 9, 0)},
+		{str: "9:45 pm", want: at(10, 14, 21, 45)},
+		{str: "midnight", want: at(10, 15, 0, 0)},
+		{str: "", wantErr: true},
+		{str: "someday", wantErr: true},
+		{str: "next week", wantErr: true},
+		{str: "in a while", wantErr: true},
+		{str: "tomorrow 13pm", wantErr: true},
+		{str: "tomorrow 24:00", wantErr: true},
+		{str: "2026-13-01", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseDeadline(test.str, now)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseDeadline(%q) = %s, expected an error", test.str, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDeadline(%q) failed: %s", test.str, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("ParseDeadline(%q) = %s, expected %s", test.str, got, test.want)
+		}
+	}
+}
+
+func TestParseFutureDeadline(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+	for _, str := range []string{"2020-01-01", "now", "in -5m", "today"} {
+		_, err := parseFutureDeadline(str, now)
+		if err == nil {
+			t.Errorf("parseFutureDeadline(%q) accepted a deadline which has passed", str)
+		}
+	}
+	for _, str := range []string{"2026-12-31", "in 1 minute", "tomorrow", "9am"} {
+		_, err := parseFutureDeadline(str, now)
+		if err != nil {
+			t.Errorf("parseFutureDeadline(%q) failed: %s", str, err)
+		}
+	}
+}
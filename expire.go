@@ -1,10 +1,11 @@
 package expire
 
 import (
+	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -17,6 +18,7 @@ import (
 // but since it's not you can just put the zero value of false
 
 const defaultFileName = ".expirations"
+const defaultArchiveDir = ".expire-archive"
 
 func DefaultDurationString() string {
 	envValue := os.Getenv("EXPIRE_DEFAULT_DURATION")
@@ -26,87 +28,117 @@ func DefaultDurationString() string {
 	return "10m"
 }
 
-func ParseDurationString(str string) (time.Duration, error) {
-	return time.ParseDuration(str)
-}
-
 func DefaultDuration() time.Duration {
 	var duration time.Duration
 
 	envValue := os.Getenv("EXPIRE_DEFAULT_DURATION")
 	if envValue != "" {
 		var err error
-		duration, err = time.ParseDuration(envValue)
+		duration, err = ParseDurationString(envValue)
 		if err != nil {
 			log.Printf("Error parsing EXPIRE_DEFAULT_DURATION environment variable: %s. Error: %s. Proceding with default duration", envValue, err.Error())
 		}
 	}
-	if duration == 0 {
+	if duration <= 0 {
 		return time.Minute * 10
 	}
 	return duration
 }
 
+// Duplicate targets are allowed, but the most recently created record for a
+// target shadows the others: it is the current record, the one every
+// command operates on. Recency is the creation sequence, then the creation
+// time for records written before sequences existed.
 type ExpirationRecords []*ExpirationRecord
 
+// Inserts the record, giving it the next creation sequence if it has none
 func (r *ExpirationRecords) insert(rec *ExpirationRecord) {
+	if rec.Seq == 0 {
+		rec.Seq = r.nextSeq()
+	}
 	*r = append(*r, rec)
 	sort.Sort(*r)
 }
 
-// Returns a copy of the first matching record
-func (r *ExpirationRecords) getFirst(predicate func(ExpirationRecord) bool) (ExpirationRecord, bool) {
-	sort.Sort(r)
+func (r *ExpirationRecords) nextSeq() int {
+	max := 0
 	for _, rec := range *r {
-		if predicate(*rec) {
-			return *rec, true
+		if rec.Seq > max {
+			max = rec.Seq
+		}
+	}
+	return max + 1
+}
+
+// Whether r was created after other, and so shadows it
+func (r ExpirationRecord) isNewerThan(other ExpirationRecord) bool {
+	if r.Seq != other.Seq {
+		return r.Seq > other.Seq
+	}
+	return r.Created.After(other.Created)
+}
+
+// The index of the current matching record, or -1
+func (r *ExpirationRecords) currentIndex(predicate func(ExpirationRecord) bool) int {
+	sort.Sort(r)
+	idx := -1
+	for i, rec := range *r {
+		if predicate(*rec) && (idx == -1 || rec.isNewerThan(*(*r)[idx])) {
+			idx = i
 		}
 	}
-	return ExpirationRecord{}, false
+	return idx
 }
 
-// Returns a copy of the first matching record
-func (r *ExpirationRecords) filter(expired bool, limit int, isDelete bool, predicate func(ExpirationRecord) bool) []*ExpirationRecord {
+// The records shadowed by a more recent record for the same target
+func (r ExpirationRecords) shadowed() map[*ExpirationRecord]bool {
+	current := make(map[string]*ExpirationRecord)
+	for _, rec := range r {
+		if cur, ok := current[rec.Target]; !ok || rec.isNewerThan(*cur) {
+			current[rec.Target] = rec
+		}
+	}
+	shadowed := make(map[*ExpirationRecord]bool)
+	for _, rec := range r {
+		if current[rec.Target] != rec {
+			shadowed[rec] = true
+		}
+	}
+	return shadowed
+}
+
+// Returns a copy of the current matching record
+func (r *ExpirationRecords) getFirst(predicate func(ExpirationRecord) bool) (ExpirationRecord, bool) {
+	idx := r.currentIndex(predicate)
+	if idx == -1 {
+		return ExpirationRecord{}, false
+	}
+	return *(*r)[idx], true
+}
+
+// Returns the matching records in order of expiration. They are the records
+// in r, not copies, so they can be used to remove them.
+func (r *ExpirationRecords) filter(expired bool, limit int, predicate func(*ExpirationRecord) bool) []*ExpirationRecord {
 	sort.Sort(r)
 	recs := make([]*ExpirationRecord, 0)
 	now := time.Now()
-	deleteIdxs := make([]int, 0)
-	for i, rec := range *r {
+	for _, rec := range *r {
 		if expired && rec.Expires.After(now) {
 			break
 		}
 		if limit > 0 && limit <= len(recs) {
 			break
 		}
-		if predicate(*rec) {
-			deleteIdxs = append(deleteIdxs, i)
-			recs = append(recs, &(*rec)) // copy it
+		if predicate(rec) {
+			recs = append(recs, rec)
 		}
 	}
-	if isDelete {
-		newRecs := make([]*ExpirationRecord, 0)
-		for i, rec := range *r {
-			if len(deleteIdxs) > 0 && i == deleteIdxs[0] {
-				deleteIdxs = deleteIdxs[1:]
-			} else {
-				newRecs = append(newRecs, rec)
-			}
-		}
-		*r = newRecs
-		sort.Sort(r)
-	}
 	return recs
 }
 
+// Deletes the current matching record, so any record it shadowed becomes current
 func (r *ExpirationRecords) deleteFirst(predicate func(ExpirationRecord) bool) (ExpirationRecord, bool) {
-	sort.Sort(r)
-	deleteIdx := -1
-	for idx, rec := range *r {
-		if predicate(*rec) {
-			deleteIdx = idx
-			break
-		}
-	}
+	deleteIdx := r.currentIndex(predicate)
 	if deleteIdx == -1 {
 		return ExpirationRecord{}, false
 	}
@@ -115,50 +147,168 @@ func (r *ExpirationRecords) deleteFirst(predicate func(ExpirationRecord) bool) (
 	return rec, true
 }
 
-func (r *ExpirationRecords) updateFirst(predicate func(ExpirationRecord) bool, action func(*ExpirationRecord)) bool {
-	sort.Sort(r)
-	found := false
+// Deletes every matching record, returning how many were deleted
+func (r *ExpirationRecords) deleteAll(predicate func(ExpirationRecord) bool) int {
+	kept := make(ExpirationRecords, 0, len(*r))
 	for _, rec := range *r {
-		if predicate(*rec) {
-			found = true
-			action(rec)
-			break
+		if !predicate(*rec) {
+			kept = append(kept, rec)
 		}
 	}
+	deleted := len(*r) - len(kept)
+	*r = kept
+	return deleted
+}
+
+// Applies the action to the current matching record
+func (r *ExpirationRecords) updateFirst(predicate func(ExpirationRecord) bool, action func(*ExpirationRecord)) bool {
+	idx := r.currentIndex(predicate)
+	if idx == -1 {
+		return false
+	}
+	action((*r)[idx])
 	sort.Sort(r)
-	return found
+	return true
 }
 
 func (r ExpirationRecords) Len() int           { return len(r) }
 func (r ExpirationRecords) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 func (r ExpirationRecords) Less(i, j int) bool { return r[i].Expires.Before(r[j].Expires) }
 
+// What a target refers to
+const (
+	KindFile = "file" // a file, relative to the expirations file
+	KindDir  = "dir"  // a directory, which rm-if-expired removes with its contents
+	KindKey  = "key"  // a name which isn't a path, always prefixed with "key:"
+)
+
+// Key targets are namespaced so they can't be mistaken for files
+const keyPrefix = "key:"
+
+func isKeyTarget(target string) bool {
+	return strings.HasPrefix(target, keyPrefix)
+}
+
+func checkKind(kind string) error {
+	switch kind {
+	case "", KindFile, KindDir, KindKey:
+		return nil
+	}
+	return fmt.Errorf("Unknown kind: %s. Should be %s, %s or %s", kind, KindFile, KindDir, KindKey)
+}
+
 type ExpirationRecord struct {
 	Target       string
+	Kind         string // KindFile, KindDir or KindKey. Empty means file
 	Expires      time.Time
 	Duration     time.Duration
+	DurationSpec string // The period as originally written, e.g. "1 month", see ParsePeriod
+	Until        string // The deadline as originally written, e.g. "tomorrow 9am"
 	ResetOnTouch bool
+	Tags         []string
+	Action       string // What to do when it expires, see RunExpired. Empty means none
+	ClaimedBy    string // The process running the action, as host:pid, see RunExpired
+
+	// history
+	Created     time.Time // when the target was first tracked
+	LastTouched time.Time // zero if never touched
+	LastRenewed time.Time // zero if never renewed
+	Notified    time.Time // when the daemon last ran the on-expired hooks for it without an action, see Daemon
+	RenewCount  int
+	Seq         int // the creation sequence within the repo, see ExpirationRecords
 
 	// optional values
 	targetFilePathAbs string
+	targetExists      bool
+	isShadowed        bool
+	// columns from the file this version doesn't know about, preserved on rewrite
+	extra map[string]string
+	// which of them hold JSON rather than strings, when read from JSON Lines
+	extraJSON map[string]bool
+}
+
+// The kind of the record, defaulting to file
+func (r ExpirationRecord) GetKind() string {
+	if r.IsKey() {
+		return KindKey
+	}
+	if r.Kind == "" {
+		return KindFile
+	}
+	return r.Kind
+}
+
+// Whether the target is a key rather than a path on the filesystem
+func (r ExpirationRecord) IsKey() bool {
+	return r.Kind == KindKey || isKeyTarget(r.Target)
 }
 
 func (r ExpirationRecord) TargetContextual() string {
-	if r.targetFilePathAbs != "" {
-		wd, err := os.Getwd()
-		if err != nil {
-			return r.Target
+	if r.targetFilePathAbs != "" && !r.IsKey() {
+		return relativeToCwd(r.targetFilePathAbs)
+	} else {
+		return r.Target
+	}
+}
+
+// When the record should expire if its timer is reset now.
+// Periods are evaluated against the calendar, so "1 month" is a calendar month.
+// Deadline phrases are re-evaluated, so "tomorrow 9am" moves to the next day.
+// Absolute deadlines which have passed fall back to the duration, or the
+// default duration if the record has none.
+func (r ExpirationRecord) nextExpiration(now time.Time, cal *Calendar) time.Time {
+	if r.Until != "" {
+		deadline, err := ParseDeadline(r.Until, now.In(cal.Location))
+		if err == nil && deadline.After(now) {
+			return deadline
 		}
+	}
+	if r.DurationSpec != "" {
+		period, err := ParsePeriod(r.DurationSpec)
+		if err == nil {
+			if expires := period.AddTo(now, cal); expires.After(now) {
+				return expires
+			}
+		}
+	}
+	if r.Duration <= 0 {
+		return now.Add(DefaultDuration())
+	}
+	return now.Add(r.Duration)
+}
 
-		relPath, err := filepath.Rel(wd, r.targetFilePathAbs)
-		if err != nil {
-			return r.Target
+func (r ExpirationRecord) HasTag(tag string) bool {
+	return containsString(r.Tags, tag)
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
 		}
+	}
+	return false
+}
 
-		return relPath
-	} else {
-		return r.Target
+// Tags can't be empty, or contain commas or whitespace
+func checkTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsAny(tag, ", \t\r\n") {
+			return fmt.Errorf("Invalid tag: %q. Tags can't be empty or contain commas or whitespace", tag)
+		}
 	}
+	return nil
+}
+
+// Whether a more recent record for the same target shadowed this one when
+// the record was queried
+func (r ExpirationRecord) Shadowed() bool {
+	return r.isShadowed
+}
+
+// Whether the target file existed when the record was queried
+func (r ExpirationRecord) Exists() bool {
+	return r.targetExists
 }
 
 func (r ExpirationRecord) ExpirationRelative() string {
@@ -168,6 +318,53 @@ func (r ExpirationRecord) ExpirationRelative() string {
 type GlobalConfig struct {
 	// The name of the file instead of "expirations"
 	Name string
+	// How long to wait for another process to release the expirations file
+	// (defaults to 10 seconds)
+	LockTimeout time.Duration
+	// The time zone calendar periods are evaluated in (defaults to $EXPIRE_TZ, then local time)
+	TimeZone string
+	// A file of holidays skipped by business days, one 2006-01-02 date per
+	// line (defaults to $EXPIRE_HOLIDAYS)
+	HolidaysFile string
+	// The format of new expirations files: csv (default) or jsonl.
+	// Existing files keep the format they are in.
+	Format string
+	// Where the archive action puts archives. Relative paths are relative to
+	// the directory containing the expirations file (defaults to
+	// $EXPIRE_ARCHIVE_DIR, then .expire-archive)
+	ArchiveDir string
+	// Don't run hooks, see runHooks
+	NoHooks bool
+	// Where the records are kept. If nil, the expirations file is located by
+	// searching up from the current directory.
+	Store Store
+}
+
+func (gc GlobalConfig) getFormat() string {
+	if gc.Format == "" {
+		return FormatCSV
+	} else {
+		return gc.Format
+	}
+}
+
+func (gc GlobalConfig) getLockTimeout() time.Duration {
+	if gc.LockTimeout == 0 {
+		return defaultLockTimeout
+	} else {
+		return gc.LockTimeout
+	}
+}
+
+func (gc GlobalConfig) getArchiveDir(expirationsPath string) (string, error) {
+	archiveDir := gc.ArchiveDir
+	if archiveDir == "" {
+		archiveDir = os.Getenv("EXPIRE_ARCHIVE_DIR")
+	}
+	if archiveDir == "" {
+		archiveDir = defaultArchiveDir
+	}
+	return resolveTarget(expirationsPath, archiveDir)
 }
 
 func (gc GlobalConfig) getFileName() string {
@@ -187,6 +384,62 @@ type BatchRunConfig struct {
 }
 
 type TargetConfig struct {
-	Target  string
-	Targets []string
+	Target          string
+	Targets         []string
+	Raw             bool // Use the targets exactly as given, e.g. for targets which aren't files
+	ResolveSymlinks bool // Resolve symlinks when canonicalizing targets
+}
+
+// The targets to operate on: Targets if given, otherwise just Target
+func (tc TargetConfig) getTargets() []string {
+	if len(tc.Targets) > 0 {
+		return tc.Targets
+	}
+	if tc.Target != "" {
+		return []string{tc.Target}
+	}
+	return nil
+}
+
+// The targets as they are stored in the expirations file, see canonicalTarget
+func (tc TargetConfig) canonicalTargets(expirationsPath string) ([]string, error) {
+	targets := tc.getTargets()
+	if tc.Raw {
+		return targets, nil
+	}
+	canonical := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if isKeyTarget(target) {
+			canonical = append(canonical, target)
+			continue
+		}
+		c, err := canonicalTarget(expirationsPath, target, tc.ResolveSymlinks)
+		if err != nil {
+			return nil, err
+		}
+		canonical = append(canonical, c)
+	}
+	return canonical, nil
+}
+
+// The failures of an operation on several targets, one per target.
+// The targets which didn't fail were still processed.
+type TargetErrors []error
+
+func (e TargetErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// nil if there were no failures. Even a single failure is returned as
+// TargetErrors, so that it is reported the same way however many targets
+// were given.
+func targetErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return TargetErrors(errs)
 }
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"github.com/washtubs/expire"
 )
@@ -34,9 +44,14 @@ func main() {
 		switch err.(type) {
 		case exitCodeError:
 			if err.Error() != "" {
-				fmt.Errorf("%s\n", err.Error())
+				fmt.Fprintln(os.Stderr, err.Error())
 			}
 			os.Exit(err.(exitCodeError).code)
+		case expire.TargetErrors:
+			for _, targetErr := range err.(expire.TargetErrors) {
+				fmt.Fprintln(os.Stderr, targetErr.Error())
+			}
+			os.Exit(1)
 		default:
 			panic(err.Error())
 		}
@@ -58,6 +73,28 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
+// A boolean flag which sets the value to false, e.g. to undo another flag
+type falseFlag struct {
+	value *bool
+}
+
+func (f *falseFlag) String() string {
+	return ""
+}
+
+func (f *falseFlag) Set(value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*f.value = !b
+	return nil
+}
+
+func (f *falseFlag) IsBoolFlag() bool {
+	return true
+}
+
 func AddDryRunFlags(fs *flag.FlagSet, config *expire.DryRunConfig) {
 	fs.BoolVar(&config.IsDryRun, "n", false, "TODO")
 }
@@ -68,13 +105,120 @@ func AddBatchRunFlags(fs *flag.FlagSet, config *expire.BatchRunConfig) {
 
 func AddGlobalFlags(fs *flag.FlagSet, config *expire.GlobalConfig) {
 	fs.StringVar(&config.Name, "name", "", "The name of the expirations file (defaults to .expirations)")
+	fs.StringVar(&config.TimeZone, "tz", "", "The time zone calendar periods are evaluated in (defaults to $EXPIRE_TZ, then local time)")
+	fs.StringVar(&config.HolidaysFile, "holidays", "", "A file of holidays to skip for business days, one YYYY-MM-DD per line (defaults to $EXPIRE_HOLIDAYS)")
+	fs.StringVar(&config.Format, "format-new", "", "The format of newly created expirations files: csv or jsonl (defaults to csv)")
+	fs.StringVar(&config.ArchiveDir, "archive-dir", "", "Where the archive action puts archives, relative to the expirations file (defaults to $EXPIRE_ARCHIVE_DIR, then .expire-archive)")
+	fs.BoolVar(&config.NoHooks, "no-hooks", false, "Don't run hooks")
+	fs.DurationVar(&config.LockTimeout, "lock-timeout", 0, "How long to wait for another process to release the expirations file (defaults to 10s)")
+}
+
+func AddFilterFlags(fs *flag.FlagSet, config *expire.FilterConfig) {
+	fs.BoolVar(&config.Expired, "expired", false, "TODO")
+	fs.BoolVar(&config.Exist, "exist", false, "TODO")
+	fs.BoolVar(&config.NoExist, "no-exist", false, "TODO")
+	fs.Var(&arrayFlags{&config.MatchGlob}, "match-glob", "Match targets against a glob, where ** spans directories and a leading ! negates (repeatable)")
+	fs.Var(&arrayFlags{&config.MatchRegex}, "match-regex", "Match targets against a regex, where a leading ! negates (repeatable)")
+	fs.BoolVar(&config.MatchAny, "any", false, "Match records matching any of the patterns")
+	fs.Var(&falseFlag{&config.MatchAny}, "all", "Match records matching all of the patterns (the default)")
+	fs.Var(&arrayFlags{&config.Tags}, "tag", "Match records with this tag (repeatable, matches any)")
+	fs.BoolVar(&config.AllTags, "all-tags", false, "Match records with all of the tags given by --tag")
+	fs.StringVar(&config.Kind, "kind", "", "Match records of this kind: file, dir or key")
+	fs.BoolVar(&config.AllShadows, "all-shadows", false, "Include records shadowed by a more recent record for the same target")
+}
+
+func AddOutputFlags(fs *flag.FlagSet, output *string) {
+	fs.StringVar(output, "output", "", "Machine-readable output: json, jsonl, csv, tsv or null")
+}
+
+func checkOutput(output string) error {
+	if output == "" {
+		return nil
+	}
+	return expire.CheckOutputFormat(output)
+}
+
+func AddTargetFlags(fs *flag.FlagSet, config *expire.TargetConfig) {
+	fs.BoolVar(&config.Raw, "raw", false, "Use targets exactly as given instead of as paths relative to the expirations file")
+	fs.BoolVar(&config.ResolveSymlinks, "resolve-symlinks", false, "Resolve symlinks in target paths")
 }
 
-func ParseTargets(fs *flag.FlagSet, config *expire.TargetConfig) {
-	config.Targets = fs.Args()
-	if len(fs.Args()) > 0 {
-		config.Target = fs.Arg(0)
+// Where to read more targets from, besides the arguments
+type targetInput struct {
+	fromFile      string
+	nullSeparated bool
+}
+
+func AddTargetInputFlags(fs *flag.FlagSet, input *targetInput) {
+	fs.StringVar(&input.fromFile, "from-file", "", "Read targets from this file, one per line")
+	fs.BoolVar(&input.nullSeparated, "0", false, "Read targets from stdin (or --from-file) separated by NUL, e.g. from find -print0")
+}
+
+// Reads targets separated by newline or NUL. Empty targets are skipped.
+func readTargets(reader io.Reader, nullSeparated bool) ([]string, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	sep := "\n"
+	if nullSeparated {
+		sep = "\x00"
+	}
+	targets := make([]string, 0)
+	for _, target := range strings.Split(string(data), sep) {
+		if !nullSeparated {
+			target = strings.TrimSuffix(target, "\r")
+		}
+		if target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// Sets the targets from the arguments. If input is given, an argument of "-"
+// reads targets from stdin, as does -0 without --from-file.
+func ParseTargets(fs *flag.FlagSet, config *expire.TargetConfig, input *targetInput) error {
+	targets := make([]string, 0, fs.NArg())
+	readStdin := false
+	for _, arg := range fs.Args() {
+		if arg == "-" && input != nil {
+			readStdin = true
+			continue
+		}
+		targets = append(targets, arg)
+	}
+
+	if input != nil {
+		if input.nullSeparated && input.fromFile == "" {
+			readStdin = true
+		}
+		if readStdin {
+			stdinTargets, err := readTargets(os.Stdin, input.nullSeparated)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, stdinTargets...)
+		}
+		if input.fromFile != "" {
+			f, err := os.Open(input.fromFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fileTargets, err := readTargets(f, input.nullSeparated)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, fileTargets...)
+		}
+	}
+
+	config.Targets = targets
+	if len(targets) > 0 {
+		config.Target = targets[0]
 	}
+	return nil
 }
 
 type exitCodeError struct {
@@ -128,6 +272,7 @@ func getInitCommand() Command {
 
 func getNewCommand() Command {
 	var (
+		input    targetInput
 		config   *expire.NewConfig
 		duration string
 	)
@@ -135,25 +280,26 @@ func getNewCommand() Command {
 
 	flags := func() *flag.FlagSet {
 		fs := flag.NewFlagSet("new", flag.ExitOnError)
-		fs.StringVar(&duration, "duration", "", "TODO")
+		fs.StringVar(&duration, "duration", "", "How long until it expires, e.g. \"15 minutes\", \"1 month\", \"2 business days\", \"end of quarter\"")
+		fs.StringVar(&config.Until, "until", "", "Expire at a date or time, e.g. 2026-12-31, \"tomorrow 9am\", \"next monday\"")
+		fs.StringVar(&config.Until, "at", "", "Alias for --until")
 		fs.BoolVar(&config.ResetOnTouch, "reset-on-touch", false, "TODO")
 		fs.BoolVar(&config.Init, "init", false, "TODO")
 		fs.BoolVar(&config.NoShadow, "no-shadow", false, "TODO")
+		fs.BoolVar(&config.Replace, "replace", false, "Replace any existing records for the target instead of shadowing them")
+		fs.Var(&arrayFlags{&config.Tags}, "tag", "Tag the record (repeatable)")
+		fs.StringVar(&config.Action, "on-expire", "", "What run-expired does when it expires: none, delete, trash, archive, archive:zst or exec:<command>")
+		fs.StringVar(&config.Kind, "kind", "", "What the target is: file, dir or key (defaults to key for key: targets, else file)")
 		AddDryRunFlags(fs, &config.DryRunConfig)
 		AddBatchRunFlags(fs, &config.BatchRunConfig)
+		AddTargetInputFlags(fs, &input)
+		AddTargetFlags(fs, &config.TargetConfig)
 		AddGlobalFlags(fs, &config.GlobalConfig)
 		return fs
 	}
 	parse := func(fs *flag.FlagSet) error {
-		if duration != "" {
-			var err error
-			config.Duration, err = expire.ParseDurationString(duration)
-			if err != nil {
-				return err
-			}
-		}
-		ParseTargets(fs, &config.TargetConfig)
-		return nil
+		config.DurationSpec = duration
+		return ParseTargets(fs, &config.TargetConfig, &input)
 	}
 	exec := func() error {
 		return expire.New(config)
@@ -167,6 +313,7 @@ func getNewCommand() Command {
 
 func getTouchCommand() Command {
 	var (
+		input  targetInput
 		config *expire.TouchConfig
 	)
 	config = &expire.TouchConfig{}
@@ -175,12 +322,13 @@ func getTouchCommand() Command {
 		fs := flag.NewFlagSet("touch", flag.ExitOnError)
 		AddDryRunFlags(fs, &config.DryRunConfig)
 		AddBatchRunFlags(fs, &config.BatchRunConfig)
+		AddTargetInputFlags(fs, &input)
+		AddTargetFlags(fs, &config.TargetConfig)
 		AddGlobalFlags(fs, &config.GlobalConfig)
 		return fs
 	}
 	parse := func(fs *flag.FlagSet) error {
-		ParseTargets(fs, &config.TargetConfig)
-		return nil
+		return ParseTargets(fs, &config.TargetConfig, &input)
 	}
 	exec := func() error {
 		return expire.Touch(config)
@@ -194,20 +342,24 @@ func getTouchCommand() Command {
 
 func getRenewCommand() Command {
 	var (
+		input  targetInput
 		config *expire.RenewConfig
 	)
 	config = &expire.RenewConfig{}
 
 	flags := func() *flag.FlagSet {
 		fs := flag.NewFlagSet("renew", flag.ExitOnError)
+		fs.StringVar(&config.Until, "until", "", "Expire at a date or time from now on, e.g. 2026-12-31, \"tomorrow 9am\"")
+		fs.StringVar(&config.Until, "at", "", "Alias for --until")
 		AddDryRunFlags(fs, &config.DryRunConfig)
 		AddBatchRunFlags(fs, &config.BatchRunConfig)
+		AddTargetInputFlags(fs, &input)
+		AddTargetFlags(fs, &config.TargetConfig)
 		AddGlobalFlags(fs, &config.GlobalConfig)
 		return fs
 	}
 	parse := func(fs *flag.FlagSet) error {
-		ParseTargets(fs, &config.TargetConfig)
-		return nil
+		return ParseTargets(fs, &config.TargetConfig, &input)
 	}
 	exec := func() error {
 		return expire.Renew(config)
@@ -221,23 +373,51 @@ func getRenewCommand() Command {
 
 func getCheckCommand() Command {
 	var (
+		input  targetInput
+		output string
 		config *expire.CheckConfig
 	)
 	config = &expire.CheckConfig{}
 
 	flags := func() *flag.FlagSet {
 		fs := flag.NewFlagSet("check", flag.ExitOnError)
+		AddOutputFlags(fs, &output)
+		AddTargetInputFlags(fs, &input)
+		AddTargetFlags(fs, &config.TargetConfig)
 		AddGlobalFlags(fs, &config.GlobalConfig)
 		return fs
 	}
 	parse := func(fs *flag.FlagSet) error {
-		ParseTargets(fs, &config.TargetConfig)
-		return nil
+		err := ParseTargets(fs, &config.TargetConfig, &input)
+		if err != nil {
+			return err
+		}
+		return checkOutput(output)
 	}
 	exec := func() error {
-		checkResp, err := expire.Check(config)
+		results, err := expire.CheckTargets(config)
+		if err != nil {
+			return exitCodeError{code: int(expire.Untracked), err: err}
+		}
+
+		if output != "" {
+			outputs := make([]expire.RecordOutput, 0, len(results))
+			for _, result := range results {
+				if result.Record != nil {
+					outputs = append(outputs, expire.NewRecordOutput(result.Record, time.Now()))
+				} else {
+					outputs = append(outputs, expire.NewUntrackedOutput(result.Target))
+				}
+			}
+			err = expire.WriteOutput(os.Stdout, output, outputs)
+		} else if len(results) > 1 {
+			// A single target is reported by the exit code alone
+			for _, result := range results {
+				fmt.Printf("%s\t%s\n", result.Response, result.Target)
+			}
+		}
 		return exitCodeError{
-			code: int(checkResp),
+			code: int(expire.AggregateCheckResponse(results)),
 			err:  err,
 		}
 	}
@@ -250,6 +430,7 @@ func getCheckCommand() Command {
 
 func getDeleteCommand() Command {
 	var (
+		input  targetInput
 		config *expire.DeleteConfig
 	)
 	config = &expire.DeleteConfig{}
@@ -259,12 +440,13 @@ func getDeleteCommand() Command {
 		fs.BoolVar(&config.DeInit, "de-init", false, "TODO")
 		AddDryRunFlags(fs, &config.DryRunConfig)
 		AddBatchRunFlags(fs, &config.BatchRunConfig)
+		AddTargetInputFlags(fs, &input)
+		AddTargetFlags(fs, &config.TargetConfig)
 		AddGlobalFlags(fs, &config.GlobalConfig)
 		return fs
 	}
 	parse := func(fs *flag.FlagSet) error {
-		ParseTargets(fs, &config.TargetConfig)
-		return nil
+		return ParseTargets(fs, &config.TargetConfig, &input)
 	}
 	exec := func() error {
 		return expire.Delete(config)
@@ -276,9 +458,81 @@ func getDeleteCommand() Command {
 	}
 }
 
+func getRmIfExpiredCommand() Command {
+	var (
+		input  targetInput
+		config *expire.RmIfExpiredConfig
+	)
+	config = &expire.RmIfExpiredConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("rm-if-expired", flag.ExitOnError)
+		fs.BoolVar(&config.Trash, "trash", false, "Move expired files to the trash instead of removing them")
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddBatchRunFlags(fs, &config.BatchRunConfig)
+		AddTargetInputFlags(fs, &input)
+		AddTargetFlags(fs, &config.TargetConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		return ParseTargets(fs, &config.TargetConfig, &input)
+	}
+	exec := func() error {
+		return expire.RmIfExpired(config)
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getMaintainCommand() Command {
+	var (
+		config   *expire.MaintainConfig
+		duration string
+	)
+	config = &expire.MaintainConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("maintain", flag.ExitOnError)
+		fs.StringVar(&duration, "duration", "", "How long until it expires, e.g. \"15 minutes\", \"1 month\", \"2 business days\", \"end of quarter\"")
+		fs.BoolVar(&config.ResetOnTouch, "reset-on-touch", false, "TODO")
+		fs.BoolVar(&config.Init, "init", false, "TODO")
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddTargetFlags(fs, &config.TargetConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		config.DurationSpec = duration
+		return ParseTargets(fs, &config.TargetConfig, nil)
+	}
+	exec := func() error {
+		resp, err := expire.Maintain(config)
+		if err != nil {
+			return err
+		}
+		switch resp {
+		case expire.Created, expire.Recreated:
+			fmt.Println("CREATE")
+		case expire.Noop:
+			fmt.Println("NOOP")
+		}
+		return nil
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
 func getNextCommand() Command {
 	var (
 		format string
+		output string
 		config *expire.NextConfig
 	)
 	config = &expire.NextConfig{}
@@ -286,20 +540,25 @@ func getNextCommand() Command {
 	flags := func() *flag.FlagSet {
 		fs := flag.NewFlagSet("next", flag.ExitOnError)
 		fs.BoolVar(&config.Delete, "delete", false, "TODO")
-		fs.BoolVar(&config.Expired, "expired", false, "TODO")
-		fs.BoolVar(&config.Exist, "exist", false, "TODO")
-		fs.BoolVar(&config.NoExist, "no-exist", false, "TODO")
-		fs.Var(&arrayFlags{&config.MatchGlob}, "match-glob", "TODO")
-		fs.Var(&arrayFlags{&config.MatchRegex}, "match-regex", "TODO")
+		AddFilterFlags(fs, &config.FilterConfig)
 		fs.IntVar(&config.Limit, "limit", 0, "TODO")
 		fs.StringVar(&format, "format", "", "TODO")
+		AddOutputFlags(fs, &output)
 		AddGlobalFlags(fs, &config.GlobalConfig)
 		return fs
 	}
 	parse := func(fs *flag.FlagSet) error {
-		return nil
+		return checkOutput(output)
 	}
 	exec := func() error {
+		if output != "" {
+			recs, err := expire.Next(config)
+			if err != nil {
+				return err
+			}
+			return expire.WriteRecordsOutput(os.Stdout, output, recs)
+		}
+
 		if format == "" {
 			format = "{{ .TargetContextual }} - {{ .ExpirationRelative }}"
 		}
@@ -329,6 +588,402 @@ func getNextCommand() Command {
 	}
 }
 
+func getScanCommand() Command {
+	var (
+		nullSeparated bool
+		output        string
+		config        *expire.ScanConfig
+	)
+	config = &expire.ScanConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("scan", flag.ExitOnError)
+		fs.BoolVar(&config.ForceRecursive, "F", false, "Recurse into subdirectories to find more repos")
+		fs.BoolVar(&config.ForceRecursive, "force-recursive", false, "Recurse into subdirectories to find more repos")
+		fs.Var(&arrayFlags{&config.Exclude}, "X", "Exclude a directory matching this glob pattern (repeatable)")
+		fs.Var(&arrayFlags{&config.Exclude}, "exclude", "Exclude a directory matching this glob pattern (repeatable)")
+		fs.BoolVar(&nullSeparated, "0", false, "Separate targets with NUL instead of newline, for xargs -0")
+		AddOutputFlags(fs, &output)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		if nullSeparated && output == "" {
+			output = expire.OutputNull
+		}
+		return checkOutput(output)
+	}
+	exec := func() error {
+		recs, err := expire.Scan(*config)
+		if err != nil {
+			return exitCodeError{
+				code: 1,
+				err:  err,
+			}
+		}
+
+		if output != "" {
+			return expire.WriteRecordsOutput(os.Stdout, output, recs)
+		}
+
+		for _, rec := range recs {
+			fmt.Println(rec.TargetContextual())
+		}
+		return nil
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getMigrateCommand() Command {
+	var (
+		config *expire.MigrateConfig
+	)
+	config = &expire.MigrateConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+		fs.StringVar(&config.To, "format", "", "The format to convert to: csv or jsonl")
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		return nil
+	}
+	exec := func() error {
+		return expire.Migrate(config)
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getNormalizeCommand() Command {
+	var (
+		config *expire.NormalizeConfig
+	)
+	config = &expire.NormalizeConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("normalize", flag.ExitOnError)
+		fs.BoolVar(&config.ResolveSymlinks, "resolve-symlinks", false, "Resolve symlinks in target paths")
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		return nil
+	}
+	exec := func() error {
+		changed, err := expire.Normalize(config)
+		if err != nil {
+			return err
+		}
+		if !config.IsDryRun {
+			fmt.Printf("Normalized %d targets\n", changed)
+		}
+		return nil
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getRestoreCommand() Command {
+	var (
+		input  targetInput
+		config *expire.RestoreConfig
+	)
+	config = &expire.RestoreConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("restore", flag.ExitOnError)
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddTargetInputFlags(fs, &input)
+		AddTargetFlags(fs, &config.TargetConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		return ParseTargets(fs, &config.TargetConfig, &input)
+	}
+	exec := func() error {
+		return expire.Restore(config)
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getDaemonCommand() Command {
+	var (
+		config *expire.DaemonConfig
+	)
+	config = &expire.DaemonConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+		fs.DurationVar(&config.PollInterval, "poll", 0, "The longest to sleep before checking for changes and missed expiries (defaults to 10s)")
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		// daemon [repo...]
+		config.Repos = fs.Args()
+		return nil
+	}
+	exec := func() error {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		return expire.Daemon(ctx, config, func(result expire.ActionResult) {
+			log.Printf("%s\t%s", result.Outcome, result.Record.Target)
+			if result.Err != nil {
+				log.Println(result.Err.Error())
+			}
+		})
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getRunExpiredCommand() Command {
+	var (
+		config *expire.RunExpiredConfig
+	)
+	config = &expire.RunExpiredConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("run-expired", flag.ExitOnError)
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		return nil
+	}
+	exec := func() error {
+		results, err := expire.RunExpired(config)
+		failed := false
+		for _, result := range results {
+			fmt.Printf("%s\t%s\n", result.Outcome, result.Record.Target)
+			if result.Err != nil {
+				fmt.Fprintln(os.Stderr, result.Err.Error())
+				failed = true
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if failed {
+			return exitCodeError{code: 1}
+		}
+		return nil
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getDedupeCommand() Command {
+	var (
+		config *expire.DedupeConfig
+	)
+	config = &expire.DedupeConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		return nil
+	}
+	exec := func() error {
+		deleted, err := expire.Dedupe(config)
+		if err != nil {
+			return err
+		}
+		if !config.IsDryRun {
+			fmt.Printf("Deleted %d shadowed records\n", deleted)
+		}
+		return nil
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+func getTagCommand() Command {
+	var (
+		action string
+		config *expire.TagConfig
+	)
+	config = &expire.TagConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("tag", flag.ExitOnError)
+		AddDryRunFlags(fs, &config.DryRunConfig)
+		AddBatchRunFlags(fs, &config.BatchRunConfig)
+		AddTargetFlags(fs, &config.TargetConfig)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		// tag add|remove <target> <tag>...
+		action = fs.Arg(0)
+		if action != "add" && action != "remove" {
+			return fmt.Errorf("Unknown tag action: %q. Should be add or remove", action)
+		}
+		config.Target = fs.Arg(1)
+		if fs.NArg() > 2 {
+			config.Tags = fs.Args()[2:]
+		}
+		return nil
+	}
+	exec := func() error {
+		if action == "add" {
+			return expire.AddTags(config)
+		} else {
+			return expire.RemoveTags(config)
+		}
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
+var listColumns = map[string]func(rec *expire.ExpirationRecord) string{
+	"target": func(rec *expire.ExpirationRecord) string {
+		return rec.TargetContextual()
+	},
+	"expires": func(rec *expire.ExpirationRecord) string {
+		return rec.Expires.Local().Format("2006-01-02 15:04:05")
+	},
+	"relative": func(rec *expire.ExpirationRecord) string {
+		return rec.ExpirationRelative()
+	},
+	"duration": func(rec *expire.ExpirationRecord) string {
+		if rec.Until != "" {
+			return "until " + rec.Until
+		}
+		if rec.DurationSpec != "" {
+			return rec.DurationSpec
+		}
+		return rec.Duration.String()
+	},
+	"reset": func(rec *expire.ExpirationRecord) string {
+		return yesNo(rec.ResetOnTouch)
+	},
+	"exists": func(rec *expire.ExpirationRecord) string {
+		if rec.IsKey() {
+			return "-"
+		}
+		return yesNo(rec.Exists())
+	},
+	"tags": func(rec *expire.ExpirationRecord) string {
+		return strings.Join(rec.Tags, ",")
+	},
+	"kind": func(rec *expire.ExpirationRecord) string {
+		return rec.GetKind()
+	},
+	"action": func(rec *expire.ExpirationRecord) string {
+		return rec.GetAction()
+	},
+	"shadowed": func(rec *expire.ExpirationRecord) string {
+		return yesNo(rec.Shadowed())
+	},
+}
+
+const defaultListColumns = "target,expires,relative,duration,reset,exists,tags"
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	} else {
+		return "no"
+	}
+}
+
+func getListCommand() Command {
+	var (
+		columns string
+		output  string
+		config  *expire.ListConfig
+	)
+	config = &expire.ListConfig{}
+
+	flags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		fs.StringVar(&config.Sort, "sort", "", "Sort by target, expires or duration (defaults to expires)")
+		fs.BoolVar(&config.Reverse, "reverse", false, "Reverse the sort order")
+		fs.StringVar(&columns, "columns", defaultListColumns, "Comma separated columns to show")
+		AddFilterFlags(fs, &config.FilterConfig)
+		AddOutputFlags(fs, &output)
+		AddGlobalFlags(fs, &config.GlobalConfig)
+		return fs
+	}
+	parse := func(fs *flag.FlagSet) error {
+		for _, column := range strings.Split(columns, ",") {
+			if _, ok := listColumns[column]; !ok {
+				names := make([]string, 0, len(listColumns))
+				for name := range listColumns {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				return fmt.Errorf("Unknown column: %s. Should be any of %s", column, strings.Join(names, ","))
+			}
+		}
+		return checkOutput(output)
+	}
+	exec := func() error {
+		recs, err := expire.List(config)
+		if err != nil {
+			return err
+		}
+
+		if output != "" {
+			return expire.WriteRecordsOutput(os.Stdout, output, recs)
+		}
+
+		cols := strings.Split(columns, ",")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(cols, "\t")))
+		for _, rec := range recs {
+			values := make([]string, 0, len(cols))
+			for _, column := range cols {
+				values = append(values, listColumns[column](rec))
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+		return w.Flush()
+	}
+	return Command{
+		flags,
+		parse,
+		exec,
+	}
+}
+
 func getCommand(cmd string) Command {
 	switch cmd {
 	case "init":
@@ -345,6 +1000,28 @@ func getCommand(cmd string) Command {
 		return getDeleteCommand()
 	case "next":
 		return getNextCommand()
+	case "rm-if-expired":
+		return getRmIfExpiredCommand()
+	case "maintain":
+		return getMaintainCommand()
+	case "scan":
+		return getScanCommand()
+	case "migrate":
+		return getMigrateCommand()
+	case "restore":
+		return getRestoreCommand()
+	case "daemon":
+		return getDaemonCommand()
+	case "run-expired":
+		return getRunExpiredCommand()
+	case "dedupe":
+		return getDedupeCommand()
+	case "normalize":
+		return getNormalizeCommand()
+	case "tag":
+		return getTagCommand()
+	case "list", "ls":
+		return getListCommand()
 	}
 	panic("Unhandled command: " + cmd)
 }
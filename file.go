@@ -3,6 +3,7 @@ package expire
 import (
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func exists(filePath string) bool {
@@ -29,10 +30,73 @@ func findFileUp(fileName string) string {
 
 		filePath = filepath.Join("..", filePath)
 	}
-	return ""
-
 }
 
 func getExpirationsFilePath(config GlobalConfig) string {
 	return findFileUp(config.getFileName())
 }
+
+// Returns the path relative to the current directory if possible
+func relativeToCwd(absPath string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return absPath
+	}
+
+	relPath, err := filepath.Rel(wd, absPath)
+	if err != nil {
+		return absPath
+	}
+
+	return relPath
+}
+
+// Resolves a target to an absolute path. Targets are relative to the
+// directory containing the expirations file, unless they are absolute.
+func resolveTarget(expirationsPath string, target string) (string, error) {
+	if filepath.IsAbs(target) {
+		return filepath.Clean(target), nil
+	}
+	absBase, err := filepath.Abs(filepath.Dir(expirationsPath))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(absBase, target), nil
+}
+
+// Converts a path relative to the current directory to the form targets are
+// stored in: cleaned, and relative to the directory containing the
+// expirations file. Paths outside of that directory are stored absolute.
+func canonicalTarget(expirationsPath string, target string, resolveSymlinks bool) (string, error) {
+	absBase, err := filepath.Abs(filepath.Dir(expirationsPath))
+	if err != nil {
+		return "", err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	if resolveSymlinks {
+		absBase = evalSymlinksExisting(absBase)
+		absTarget = evalSymlinksExisting(absTarget)
+	}
+
+	rel, err := filepath.Rel(absBase, absTarget)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return absTarget, nil
+	}
+	return rel, nil
+}
+
+// Resolves the symlinks in as much of the path as exists
+func evalSymlinksExisting(absPath string) string {
+	resolved, err := filepath.EvalSymlinks(absPath)
+	if err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(absPath)
+	if parent == absPath {
+		return absPath
+	}
+	return filepath.Join(evalSymlinksExisting(parent), filepath.Base(absPath))
+}
@@ -0,0 +1,94 @@
+package expire
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// The default Store: an expirations file, locked with a sidecar lock file.
+// Existing files are rewritten in the format they were read in.
+type fileStore struct {
+	path        string
+	lockTimeout time.Duration
+	// The format to use if the file doesn't exist yet
+	defaultFormat string
+}
+
+func newFileStore(expirationsPath string, config GlobalConfig) *fileStore {
+	return &fileStore{
+		path:          expirationsPath,
+		lockTimeout:   config.getLockTimeout(),
+		defaultFormat: config.getFormat(),
+	}
+}
+
+func (s *fileStore) Path() string {
+	return s.path
+}
+
+func (s *fileStore) Load() (ExpirationRecords, error) {
+	records, _, err := readRecordsFromFile(s.path)
+	return records, err
+}
+
+func (s *fileStore) Save(records ExpirationRecords) error {
+	unlock, err := acquireLock(s.path, s.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	format := s.defaultFormat
+	content, err := ioutil.ReadFile(s.path)
+	if err == nil {
+		format = detectFormat(content)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return writeRecordsToFile(s.path, format, records)
+}
+
+func (s *fileStore) Transaction(fn func(records *ExpirationRecords) (bool, error)) error {
+	unlock, err := acquireLock(s.path, s.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	records, format, err := readRecordsFromFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	write, err := fn(&records)
+	if err != nil || !write {
+		return err
+	}
+
+	return writeRecordsToFile(s.path, format, records)
+}
+
+// Rewrites the file in another format
+func (s *fileStore) convert(format string) error {
+	unlock, err := acquireLock(s.path, s.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	records, _, err := readRecordsFromFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	return writeRecordsToFile(s.path, format, records)
+}
+
+// Removes the expirations file. The lock file is left in place: removing it
+// while locked would let a process waiting on it and a process creating a
+// new one both hold the lock.
+func (s *fileStore) Remove() error {
+	return os.Remove(s.path)
+}
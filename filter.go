@@ -0,0 +1,168 @@
+package expire
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+)
+
+// Selects records. Shared by the commands which query records.
+type FilterConfig struct {
+	Expired    bool     // Match expired records only
+	Exist      bool     // Match records corresponding to files that exist
+	NoExist    bool     // Match records corresponding to files that don't exist
+	MatchGlob  []string // Match according to glob patterns, see compilePatterns
+	MatchRegex []string // Match according to regex patterns
+	MatchAny   bool     // Match records matching any of the patterns instead of all
+	Tags       []string // Match records with any of these tags
+	AllTags    bool     // Match records with all of the tags instead of any
+	Kind       string   // Match records of this kind: file, dir or key
+	AllShadows bool     // Match records shadowed by a more recent record for the same target too
+}
+
+func checkFilter(config *FilterConfig) error {
+	if config.Exist && config.NoExist {
+		log.Println("Competing configs set, exist and noexist. Using exist.")
+		config.NoExist = false
+	}
+	return checkKind(config.Kind)
+}
+
+// A glob or regex pattern which matches a record if it matches the stored
+// target, or for files, the path relative to the current directory
+type pattern struct {
+	match  func(string) bool
+	negate bool
+}
+
+func (p pattern) matches(candidates []string) bool {
+	for _, candidate := range candidates {
+		if p.match(candidate) {
+			return !p.negate
+		}
+	}
+	return p.negate
+}
+
+// Compiles the glob and regex patterns of the filter.
+// Globs use '/' as the separator: "*" matches within a path segment and "**"
+// across segments. A pattern starting with "!" matches records the rest of
+// it doesn't match.
+func compilePatterns(config FilterConfig) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(config.MatchGlob)+len(config.MatchRegex))
+	for _, globStr := range config.MatchGlob {
+		negate := strings.HasPrefix(globStr, "!")
+		g, err := glob.Compile(strings.TrimPrefix(globStr, "!"), '/')
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing glob %s", globStr)
+		}
+		patterns = append(patterns, pattern{g.Match, negate})
+	}
+	for _, regexStr := range config.MatchRegex {
+		negate := strings.HasPrefix(regexStr, "!")
+		regex, err := regexp.Compile(strings.TrimPrefix(regexStr, "!"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing regex %s", regexStr)
+		}
+		patterns = append(patterns, pattern{regex.MatchString, negate})
+	}
+	return patterns, nil
+}
+
+func matchPatterns(patterns []pattern, any bool, candidates []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if p.matches(candidates) {
+			if any {
+				return true
+			}
+		} else if !any {
+			return false
+		}
+	}
+	return !any
+}
+
+// Returns the matching records, annotated with their resolved paths
+func filterRecords(config FilterConfig, expirationsPath string, records *ExpirationRecords, limit int) ([]*ExpirationRecord, error) {
+	patterns, err := compilePatterns(config)
+	if err != nil {
+		return nil, err
+	}
+
+	targetToFile := make(map[string]string)
+	targetExists := make(map[string]bool)
+	shadowed := records.shadowed()
+
+	filtered := records.filter(config.Expired, limit, func(rp *ExpirationRecord) bool {
+		r := *rp
+		if shadowed[rp] && !config.AllShadows {
+			return false
+		}
+
+		if config.Kind != "" && r.GetKind() != config.Kind {
+			return false
+		}
+
+		if len(config.Tags) > 0 && !matchTags(r, config.Tags, config.AllTags) {
+			return false
+		}
+
+		if r.IsKey() {
+			// Keys aren't files, so they neither exist nor don't
+			if config.Exist || config.NoExist {
+				return false
+			}
+			return matchPatterns(patterns, config.MatchAny, []string{r.Target})
+		}
+
+		candidates := []string{filepath.ToSlash(r.Target)}
+		fileExists := false
+		abs, err := resolveTarget(expirationsPath, r.Target)
+		if err == nil {
+			targetToFile[r.Target] = abs
+			fileExists = exists(abs)
+			targetExists[r.Target] = fileExists
+			candidates = append(candidates, filepath.ToSlash(relativeToCwd(abs)))
+		}
+
+		if config.Exist && !fileExists {
+			return false
+		}
+
+		if config.NoExist && fileExists {
+			return false
+		}
+
+		return matchPatterns(patterns, config.MatchAny, candidates)
+	})
+
+	for _, rec := range filtered {
+		if val, pres := targetToFile[rec.Target]; pres {
+			rec.targetFilePathAbs = val
+		}
+		rec.targetExists = targetExists[rec.Target]
+		rec.isShadowed = shadowed[rec]
+	}
+
+	return filtered, nil
+}
+
+func matchTags(r ExpirationRecord, tags []string, all bool) bool {
+	for _, tag := range tags {
+		if r.HasTag(tag) {
+			if !all {
+				return true
+			}
+		} else if all {
+			return false
+		}
+	}
+	return all
+}
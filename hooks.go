@@ -0,0 +1,137 @@
+package expire
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Hooks are executables named after an event, found in .expire-hooks next to
+// the expirations file and in $XDG_CONFIG_HOME/expire/hooks. The user's hooks
+// run before the repo's.
+//
+// A hook gets the record as JSON on stdin (see RecordOutput) and in the
+// environment as EXPIRE_EVENT, EXPIRE_TARGET, EXPIRE_PATH, EXPIRE_EXPIRES,
+// EXPIRE_KIND, EXPIRE_TAGS and EXPIRE_FILE (the expirations file). It runs in
+// the directory containing the expirations file, and its output goes to
+// stderr.
+//
+// Pre hooks run while the expirations file is locked, and a failing pre hook
+// aborts the operation for that record. They may query the repo, but must
+// not modify it. Post hooks run after the change is saved, and their
+// failures are only logged. The exception is run-expired, which runs its
+// on-expired and pre-delete hooks, like its actions, without the lock.
+const (
+	HookPreNew     = "pre-new"
+	HookPostNew    = "post-new"
+	HookPreTouch   = "pre-touch"
+	HookPostTouch  = "post-touch"
+	HookPreRenew   = "pre-renew"
+	HookPostRenew  = "post-renew"
+	HookPreDelete  = "pre-delete"
+	HookPostDelete = "post-delete"
+	HookOnExpired  = "on-expired" // when an expired record is acted on, swept, or found by the daemon without an action
+)
+
+const repoHooksDir = ".expire-hooks"
+
+// The directories hooks are looked up in, in the order they run
+func hookDirs(expirationsPath string) []string {
+	dirs := make([]string, 0, 2)
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		dirs = append(dirs, filepath.Join(configHome, "expire", "hooks"))
+	}
+	repoDir, err := filepath.Abs(filepath.Dir(expirationsPath))
+	if err == nil {
+		dirs = append(dirs, filepath.Join(repoDir, repoHooksDir))
+	}
+	return dirs
+}
+
+// The executable hooks for the event, in the order they run
+func findHooks(config GlobalConfig, event string, expirationsPath string) []string {
+	if config.NoHooks {
+		return nil
+	}
+	hooks := make([]string, 0)
+	for _, dir := range hookDirs(expirationsPath) {
+		hookPath := filepath.Join(dir, event)
+		info, err := os.Stat(hookPath)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if info.Mode()&0111 == 0 {
+			log.Printf("Ignoring hook %s: not executable", hookPath)
+			continue
+		}
+		hooks = append(hooks, hookPath)
+	}
+	return hooks
+}
+
+// Runs the hooks for the event, stopping at the first which fails.
+// Returns whether there were any hooks to run.
+func runHooks(config GlobalConfig, event string, rec ExpirationRecord, expirationsPath string) (bool, error) {
+	hooks := findHooks(config, event, expirationsPath)
+	for _, hookPath := range hooks {
+		err := runHook(hookPath, event, rec, expirationsPath)
+		if err != nil {
+			return true, fmt.Errorf("%s hook for %s failed: %s", event, rec.Target, err)
+		}
+	}
+	return len(hooks) > 0, nil
+}
+
+// Runs the post hooks for each record, logging failures
+func runPostHooks(config GlobalConfig, event string, recs []ExpirationRecord, expirationsPath string) {
+	for _, rec := range recs {
+		_, err := runHooks(config, event, rec, expirationsPath)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+func runHook(hookPath string, event string, rec ExpirationRecord, expirationsPath string) error {
+	if !rec.IsKey() && rec.targetFilePathAbs == "" {
+		rec.targetFilePathAbs, _ = resolveTarget(expirationsPath, rec.Target)
+		rec.targetExists = exists(rec.targetFilePathAbs)
+	}
+	input, err := json.Marshal(NewRecordOutput(&rec, time.Now()))
+	if err != nil {
+		return err
+	}
+	absExpirationsPath, err := filepath.Abs(expirationsPath)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(hookPath)
+	cmd.Dir = filepath.Dir(absExpirationsPath)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"EXPIRE_EVENT="+event,
+		"EXPIRE_TARGET="+rec.Target,
+		"EXPIRE_PATH="+rec.targetFilePathAbs,
+		"EXPIRE_EXPIRES="+rec.Expires.Format(dateTimeFormat),
+		"EXPIRE_KIND="+rec.GetKind(),
+		"EXPIRE_TAGS="+strings.Join(rec.Tags, ","),
+		"EXPIRE_FILE="+absExpirationsPath,
+	)
+	return cmd.Run()
+}
@@ -12,6 +12,11 @@ type InitConfig struct {
 // Initializes an expirations file in the current directory
 // If the file already exists, it will do nothing
 func Init(config *InitConfig) error {
+	if config.Store != nil {
+		// Nothing to initialize
+		return nil
+	}
+
 	_, err := os.Stat(config.getFileName())
 	if !os.IsNotExist(err) {
 		if config.IsDryRun {
@@ -25,5 +30,16 @@ func Init(config *InitConfig) error {
 		return nil
 	}
 
-	return writeRecordsToFile(config.getFileName(), ExpirationRecords{})
+	unlock, err := acquireLock(config.getFileName(), config.getLockTimeout())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another process may have initialized it while we waited for the lock
+	if exists(config.getFileName()) {
+		return nil
+	}
+
+	return writeRecordsToFile(config.getFileName(), config.getFormat(), ExpirationRecords{})
 }
@@ -0,0 +1,295 @@
+package expire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The JSON Lines format: a header line carrying the schema version, followed
+// by one JSON object per record.
+//
+//	{"format":"expirations","version":1}
+//	{"target":"a.txt","expires":"2020-01-01T00:00:00Z","duration":"10m0s","resetOnTouch":false}
+
+const jsonlFormatName = "expirations"
+const jsonlFormatVersion = 1
+
+type jsonlHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+type jsonlRecord struct {
+	Target       string   `json:"target"`
+	Kind         string   `json:"kind,omitempty"`
+	Expires      string   `json:"expires"`
+	Duration     string   `json:"duration"`
+	DurationSpec string   `json:"durationSpec,omitempty"`
+	Until        string   `json:"until,omitempty"`
+	ResetOnTouch bool     `json:"resetOnTouch"`
+	Tags         []string `json:"tags,omitempty"`
+	Action       string   `json:"action,omitempty"`
+	ClaimedBy    string   `json:"claimedBy,omitempty"`
+	Created      string   `json:"created,omitempty"`
+	LastTouched  string   `json:"lastTouched,omitempty"`
+	LastRenewed  string   `json:"lastRenewed,omitempty"`
+	Notified     string   `json:"notified,omitempty"`
+	RenewCount   int      `json:"renewCount,omitempty"`
+	Seq          int      `json:"seq,omitempty"`
+
+	// Fields this version doesn't know about, preserved on rewrite
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// The fields of jsonlRecord, without its methods
+type jsonlRecordFields jsonlRecord
+
+// The names of the fields this version knows about
+var jsonlFieldNames = func() map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeOf(jsonlRecordFields{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+func (jr *jsonlRecord) UnmarshalJSON(data []byte) error {
+	err := json.Unmarshal(data, (*jsonlRecordFields)(jr))
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	jr.Extra = nil
+	for name, value := range fields {
+		if !jsonlFieldNames[name] {
+			if jr.Extra == nil {
+				jr.Extra = make(map[string]json.RawMessage)
+			}
+			jr.Extra[name] = value
+		}
+	}
+	return nil
+}
+
+// The unknown fields go after the known ones, sorted by name
+func (jr jsonlRecord) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(jsonlRecordFields(jr))
+	if err != nil || len(jr.Extra) == 0 {
+		return data, err
+	}
+
+	names := make([]string, 0, len(jr.Extra))
+	for name := range jr.Extra {
+		if !jsonlFieldNames[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1])
+	for _, name := range names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(jr.Extra[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func readJSONLRecords(reader io.Reader) (ExpirationRecords, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	out := make(ExpirationRecords, 0)
+	lineNum := 0
+	sawHeader := false
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !sawHeader {
+			var header jsonlHeader
+			err := json.Unmarshal([]byte(line), &header)
+			if err != nil || header.Format != jsonlFormatName {
+				return nil, fmt.Errorf("Error parsing expirations file (line #%d): missing header", lineNum)
+			}
+			if header.Version > jsonlFormatVersion {
+				return nil, fmt.Errorf("Expirations file was written by a newer version of expire (format version %d, supported up to %d)", header.Version, jsonlFormatVersion)
+			}
+			sawHeader = true
+			continue
+		}
+
+		var jr jsonlRecord
+		err := json.Unmarshal([]byte(line), &jr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing expirations file (line #%d): %s", lineNum, err)
+		}
+		rec, err := fromJSONLRecord(jr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing expirations file (line #%d): %s", lineNum, err)
+		}
+		out = append(out, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func writeJSONLRecords(writer io.Writer, recs ExpirationRecords) error {
+	w := bufio.NewWriter(writer)
+	enc := json.NewEncoder(w)
+
+	err := enc.Encode(jsonlHeader{
+		Format:  jsonlFormatName,
+		Version: jsonlFormatVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recs {
+		err := enc.Encode(toJSONLRecord(*r))
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+func fromJSONLRecord(jr jsonlRecord) (*ExpirationRecord, error) {
+	expires, err := time.Parse(dateTimeFormat, jr.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := ParseDurationString(jr.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := parseOptionalTime(jr.Created)
+	if err != nil {
+		return nil, err
+	}
+	lastTouched, err := parseOptionalTime(jr.LastTouched)
+	if err != nil {
+		return nil, err
+	}
+	lastRenewed, err := parseOptionalTime(jr.LastRenewed)
+	if err != nil {
+		return nil, err
+	}
+	notified, err := parseOptionalTime(jr.Notified)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strings are kept as they are, so they can go to CSV as they were,
+	// anything else as JSON
+	var extra map[string]string
+	var extraJSON map[string]bool
+	for name, value := range jr.Extra {
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		var str string
+		if json.Unmarshal(value, &str) == nil {
+			extra[name] = str
+			continue
+		}
+		if extraJSON == nil {
+			extraJSON = make(map[string]bool)
+		}
+		extra[name] = string(value)
+		extraJSON[name] = true
+	}
+
+	return &ExpirationRecord{
+		Target:       jr.Target,
+		Kind:         jr.Kind,
+		Expires:      expires,
+		Duration:     duration,
+		DurationSpec: jr.DurationSpec,
+		Until:        jr.Until,
+		ResetOnTouch: jr.ResetOnTouch,
+		Tags:         jr.Tags,
+		Action:       jr.Action,
+		ClaimedBy:    jr.ClaimedBy,
+		Created:      created,
+		LastTouched:  lastTouched,
+		LastRenewed:  lastRenewed,
+		Notified:     notified,
+		RenewCount:   jr.RenewCount,
+		Seq:          jr.Seq,
+		extra:        extra,
+		extraJSON:    extraJSON,
+	}, nil
+}
+
+func toJSONLRecord(e ExpirationRecord) jsonlRecord {
+	var extra map[string]json.RawMessage
+	for name, value := range e.extra {
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		if e.extraJSON[name] && json.Valid([]byte(value)) {
+			extra[name] = json.RawMessage(value)
+			continue
+		}
+		str, err := json.Marshal(value)
+		if err == nil {
+			extra[name] = str
+		}
+	}
+
+	return jsonlRecord{
+		Target:       e.Target,
+		Kind:         e.Kind,
+		Expires:      e.Expires.Format(dateTimeFormat),
+		Duration:     e.Duration.String(),
+		DurationSpec: e.DurationSpec,
+		Until:        e.Until,
+		ResetOnTouch: e.ResetOnTouch,
+		Tags:         e.Tags,
+		Action:       e.Action,
+		ClaimedBy:    e.ClaimedBy,
+		Created:      formatOptionalTime(e.Created),
+		LastTouched:  formatOptionalTime(e.LastTouched),
+		LastRenewed:  formatOptionalTime(e.LastRenewed),
+		Notified:     formatOptionalTime(e.Notified),
+		RenewCount:   e.RenewCount,
+		Seq:          e.Seq,
+		Extra:        extra,
+	}
+}
@@ -0,0 +1,63 @@
+package expire
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUnknownColumnsSurviveMigration(t *testing.T) {
+	csvContent := "#version=1\n" +
+		"target,expires,note\n" +
+		"a.txt,2026-01-02T03:04:05Z,keep me\n"
+
+	recs, err := readRecords(strings.NewReader(csvContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonl bytes.Buffer
+	err = writeJSONLRecords(&jsonl, recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(jsonl.String(), `"note":"keep me"`) {
+		t.Errorf("The note column was lost migrating to JSON Lines:\n%s", jsonl.String())
+	}
+
+	recs, err = readJSONLRecords(&jsonl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var csvOut bytes.Buffer
+	err = writeRecords(&csvOut, recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := readRecords(&csvOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != 1 || back[0].extra["note"] != "keep me" {
+		t.Errorf("The note column was lost migrating back to CSV:\n%s", csvOut.String())
+	}
+}
+
+func TestUnknownJSONLFieldsSurviveRewrite(t *testing.T) {
+	content := `{"format":"expirations","version":1}` + "\n" +
+		`{"target":"a.txt","expires":"2026-01-02T03:04:05Z","duration":"10m0s","resetOnTouch":false,"priority":3,"owner":{"name":"ops"},"note":"hi"}` + "\n"
+
+	recs, err := readJSONLRecords(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = writeJSONLRecords(&out, recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"priority":3`, `"owner":{"name":"ops"}`, `"note":"hi"`} {
+		if !strings.Contains(out.String(), field) {
+			t.Errorf("%s was lost on rewrite:\n%s", field, out.String())
+		}
+	}
+}
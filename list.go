@@ -0,0 +1,72 @@
+package expire
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	SortByExpires  = "expires"
+	SortByTarget   = "target"
+	SortByDuration = "duration"
+)
+
+type ListConfig struct {
+	GlobalConfig
+	FilterConfig
+	Sort    string // expires (default), target or duration
+	Reverse bool
+}
+
+func checkList(config *ListConfig) error {
+	switch config.Sort {
+	case "", SortByExpires, SortByTarget, SortByDuration:
+	default:
+		return fmt.Errorf("Unknown sort: %s. Should be %s, %s or %s", config.Sort, SortByExpires, SortByTarget, SortByDuration)
+	}
+	return checkFilter(&config.FilterConfig)
+}
+
+// Returns the matching records. Unlike Next, it never modifies the repo.
+func List(config *ListConfig) ([]*ExpirationRecord, error) {
+	err := checkList(config)
+	if err != nil {
+		return nil, err
+	}
+
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		return nil, errors.New("No expirations file")
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// sorted by expiration
+	filtered, err := filterRecords(config.FilterConfig, store.Path(), &records, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Sort {
+	case SortByTarget:
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].Target < filtered[j].Target
+		})
+	case SortByDuration:
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].Duration < filtered[j].Duration
+		})
+	}
+
+	if config.Reverse {
+		for i, j := 0, len(filtered)-1; i < j; i, j = i+1, j-1 {
+			filtered[i], filtered[j] = filtered[j], filtered[i]
+		}
+	}
+
+	return filtered, nil
+}
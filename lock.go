@@ -0,0 +1,43 @@
+package expire
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+const defaultLockTimeout = 10 * time.Second
+const lockRetryInterval = 10 * time.Millisecond
+
+// The lock is taken on a sidecar file rather than the expirations file itself
+// so that the expirations file can be replaced while the lock is held.
+// Symlinks are resolved first, so that a symlinked expirations file shares
+// the lock of the file it points to, which is the one written.
+func getLockFilePath(expirationsPath string) string {
+	resolved, err := filepath.EvalSymlinks(expirationsPath)
+	if err == nil {
+		expirationsPath = resolved
+	}
+	return expirationsPath + ".lock"
+}
+
+// Acquires an exclusive advisory lock for the expirations file, waiting up
+// to timeout for other processes to release it.
+// Returns a function which releases the lock.
+func acquireLock(expirationsPath string, timeout time.Duration) (func() error, error) {
+	lockPath := getLockFilePath(expirationsPath)
+	deadline := time.Now().Add(timeout)
+	for {
+		unlock, err := tryLock(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to lock %s: %s", lockPath, err)
+		}
+		if unlock != nil {
+			return unlock, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out after %s waiting for the lock on %s. Is another expire process running?", timeout, lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
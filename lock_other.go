@@ -0,0 +1,30 @@
+//go:build !unix
+
+package expire
+
+import (
+	"os"
+)
+
+// Without flock, the lock is held by creating the lock file, and released
+// by removing it. A process which dies holding it leaves it behind, and it
+// has to be removed by hand.
+// Returns a function which releases it, or nil if another process holds it.
+func tryLock(lockPath string) (func() error, error) {
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		closeErr := f.Close()
+		err := os.Remove(lockPath)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package expire
+
+import (
+	"os"
+	"syscall"
+)
+
+// Tries once to take an flock on the lock file, without waiting.
+// Returns a function which releases it, or nil if another process holds it.
+func tryLock(lockPath string) (func() error, error) {
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+		f.Close()
+		return nil, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() error {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		closeErr := f.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	}, nil
+}
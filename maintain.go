@@ -0,0 +1,185 @@
+package expire
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type MaintainConfig struct {
+	GlobalConfig
+	DryRunConfig
+	TargetConfig
+
+	// Initialization options. These are only used the first time, when the
+	// record is created. Thereafter they are ignored.
+	Init         bool
+	Duration     time.Duration
+	DurationSpec string
+	ResetOnTouch bool
+}
+
+func checkMaintain(config *MaintainConfig) error {
+	targets := config.getTargets()
+	if len(targets) == 0 {
+		return errors.New("No target")
+	}
+	if len(targets) > 1 {
+		return fmt.Errorf("Only one target may be maintained at a time, got %d", len(targets))
+	}
+	return nil
+}
+
+type MaintainResponse int
+
+const (
+	Noop      MaintainResponse = 0 // The file exists and is unexpired, no change
+	Created   MaintainResponse = 1 // The file was created, and the record initialized if there was none
+	Recreated MaintainResponse = 2 // The record was expired, so it was renewed and the file recreated
+)
+
+// Ensures the target file exists and is unexpired.
+// If it is untracked, a record is created and the file is (re)created empty.
+// If it is expired, the record is renewed and the file is recreated empty.
+// If it is unexpired but the file is missing, the file is created empty.
+// If it returns without error, the file always exists.
+// Key targets only have their record maintained.
+func Maintain(config *MaintainConfig) (MaintainResponse, error) {
+	err := checkMaintain(config)
+	if err != nil {
+		return Noop, err
+	}
+
+	newConfig := &NewConfig{
+		GlobalConfig: config.GlobalConfig,
+		DryRunConfig: config.DryRunConfig,
+		TargetConfig: config.TargetConfig,
+		Init:         config.Init,
+		Duration:     config.Duration,
+		DurationSpec: config.DurationSpec,
+		ResetOnTouch: config.ResetOnTouch,
+	}
+
+	err = checkNew(newConfig)
+	if err != nil {
+		return Noop, err
+	}
+
+	cal, err := loadCalendar(config.GlobalConfig)
+	if err != nil {
+		return Noop, err
+	}
+
+	store := findStore(config.GlobalConfig)
+	if store == nil && config.Init {
+		err := Init(&InitConfig{
+			config.GlobalConfig,
+			config.DryRunConfig,
+		})
+		if err != nil {
+			return Noop, err
+		}
+
+		store = findStore(config.GlobalConfig)
+	}
+
+	if store == nil {
+		if config.IsDryRun && config.Init {
+			dryRunReporter.ReportAction("Would insert %#v", newRecord(newConfig, newConfig.Target, cal))
+			dryRunReporter.ReportAction("Would create the file: %s", config.getTargets()[0])
+			return Created, nil
+		}
+		return Noop, errors.New("No expirations file. Use init or the init config option to create one")
+	}
+
+	targets, err := config.canonicalTargets(store.Path())
+	if err != nil {
+		return Noop, err
+	}
+	target := targets[0]
+
+	isTarget := func(rec ExpirationRecord) bool {
+		return rec.Target == target
+	}
+
+	var resp MaintainResponse
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		rec, ok := records.getFirst(isTarget)
+		if !ok {
+			resp = Created
+			rec = *newRecord(newConfig, target, cal)
+			records.insert(&rec)
+		} else if rec.Expires.After(time.Now()) {
+			resp = Noop
+		} else {
+			resp = Recreated
+			records.updateFirst(isTarget, func(rec *ExpirationRecord) {
+				renewRecord(rec, cal)
+			})
+		}
+
+		if rec.IsKey() {
+			// Nothing to create, just keep the record alive
+			if config.IsDryRun {
+				if resp == Created {
+					dryRunReporter.ReportAction("Would insert %#v", &rec)
+				} else if resp == Recreated {
+					dryRunReporter.ReportAction("Will renew record: %s", target)
+				}
+				return false, nil
+			}
+			return resp != Noop, nil
+		}
+
+		targetPath, err := resolveTarget(store.Path(), rec.Target)
+		if err != nil {
+			return false, err
+		}
+
+		if resp == Noop {
+			// Unexpired, but make sure the file is still there
+			if exists(targetPath) {
+				if config.IsDryRun {
+					dryRunReporter.ReportAction("Will not recreate unexpired target: %s", target)
+				}
+				return false, nil
+			}
+			resp = Created
+			if config.IsDryRun {
+				dryRunReporter.ReportAction("Will create the missing file: %s", targetPath)
+				return false, nil
+			}
+			return false, recreateFile(targetPath)
+		}
+
+		if config.IsDryRun {
+			if resp == Created {
+				dryRunReporter.ReportAction("Would insert %#v", &rec)
+			} else {
+				dryRunReporter.ReportAction("Will renew record: %s", target)
+			}
+			dryRunReporter.ReportAction("Will recreate the file: %s", targetPath)
+			return false, nil
+		}
+
+		return true, recreateFile(targetPath)
+	})
+	if err != nil {
+		return Noop, err
+	}
+	return resp, nil
+}
+
+// Replaces the file with a new empty one
+func recreateFile(filePath string) error {
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,67 @@
+package expire
+
+import (
+	"sync"
+)
+
+// A Store which only lives in memory, useful for tests.
+// Set it as GlobalConfig.Store to use it instead of an expirations file.
+type MemoryStore struct {
+	mu      sync.Mutex
+	path    string
+	records ExpirationRecords
+}
+
+// Creates a store holding a copy of records.
+// Targets are resolved relative to the directory of path.
+func NewMemoryStore(path string, records ExpirationRecords) *MemoryStore {
+	return &MemoryStore{
+		path:    path,
+		records: copyRecords(records),
+	}
+}
+
+func copyRecords(records ExpirationRecords) ExpirationRecords {
+	out := make(ExpirationRecords, 0, len(records))
+	for _, rec := range records {
+		c := *rec
+		out = append(out, &c)
+	}
+	return out
+}
+
+func (s *MemoryStore) Path() string {
+	return s.path
+}
+
+func (s *MemoryStore) Load() (ExpirationRecords, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyRecords(s.records), nil
+}
+
+func (s *MemoryStore) Save(records ExpirationRecords) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = copyRecords(records)
+	return nil
+}
+
+func (s *MemoryStore) Transaction(fn func(records *ExpirationRecords) (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := copyRecords(s.records)
+	write, err := fn(&records)
+	if err != nil || !write {
+		return err
+	}
+	s.records = copyRecords(records)
+	return nil
+}
+
+func (s *MemoryStore) Remove() error {
+	// Called within a transaction, so the lock is not taken here
+	s.records = ExpirationRecords{}
+	return nil
+}
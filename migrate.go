@@ -0,0 +1,44 @@
+package expire
+
+import (
+	"errors"
+)
+
+type MigrateConfig struct {
+	GlobalConfig
+	DryRunConfig
+	// The format to convert to: csv or jsonl
+	To string
+}
+
+func checkMigrate(config *MigrateConfig) error {
+	if config.To == "" {
+		return errors.New("No format")
+	}
+	return checkFormat(config.To)
+}
+
+// Converts the current expirations file to another format in place
+func Migrate(config *MigrateConfig) error {
+	err := checkMigrate(config)
+	if err != nil {
+		return err
+	}
+
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		return errors.New("No expirations file")
+	}
+
+	fs, ok := store.(*fileStore)
+	if !ok {
+		return errors.New("Only expirations files can be migrated")
+	}
+
+	if config.IsDryRun {
+		dryRunReporter.ReportAction("Would convert %s to %s", fs.Path(), config.To)
+		return nil
+	}
+
+	return fs.convert(config.To)
+}
@@ -2,6 +2,7 @@ package expire
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -12,15 +13,120 @@ type NewConfig struct {
 	TargetConfig
 	Init         bool
 	Duration     time.Duration
+	DurationSpec string // A calendar period, e.g. "1 month", see ParsePeriod. Overrides Duration
+	Until        string // Expire at this deadline instead, see ParseDeadline
 	ResetOnTouch bool
 	NoShadow     bool
+	Replace      bool // Replace any records for the target instead of shadowing them
+	Tags         []string
+	Action       string // What to do when it expires, see RunExpired
+	Kind         string // KindFile, KindDir or KindKey. Defaults to key for "key:" targets, else file
 }
 
 func checkNew(config *NewConfig) error {
-	if config.Target == "" {
+	if len(config.getTargets()) == 0 {
 		return errors.New("No target")
 	}
-	return nil
+	if config.Duration < 0 {
+		return fmt.Errorf("Invalid duration: %s. It must be positive", config.Duration)
+	}
+	if config.DurationSpec != "" {
+		cal, err := loadCalendar(config.GlobalConfig)
+		if err != nil {
+			return err
+		}
+		err = checkPeriod(config.DurationSpec, time.Now(), cal)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Until != "" {
+		if config.Duration != 0 || config.DurationSpec != "" {
+			return errors.New("Only one of duration and until may be given")
+		}
+		_, err := parseFutureDeadline(config.Until, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	if config.NoShadow && config.Replace {
+		return errors.New("Only one of no shadow and replace may be given")
+	}
+	err := checkKind(config.Kind)
+	if err != nil {
+		return err
+	}
+	err = checkAction(config.Action)
+	if err != nil {
+		return err
+	}
+	if config.Kind == KindFile || config.Kind == KindDir {
+		for _, target := range config.getTargets() {
+			if isKeyTarget(target) {
+				return fmt.Errorf("Invalid %s target: %s. Targets starting with %q are keys", config.Kind, target, keyPrefix)
+			}
+		}
+	}
+	return checkTags(config.Tags)
+}
+
+// Prefixes the targets which aren't keys already
+func withKeyPrefix(targets []string) []string {
+	keys := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if !isKeyTarget(target) {
+			target = keyPrefix + target
+		}
+		keys = append(keys, target)
+	}
+	return keys
+}
+
+// Expects a config which passed checkNew
+func newRecord(config *NewConfig, target string, cal *Calendar) *ExpirationRecord {
+	duration := config.Duration
+	if duration == 0 {
+		duration = DefaultDuration()
+	}
+
+	now := time.Now()
+	expires := now.Add(duration)
+	if config.DurationSpec != "" {
+		period, err := ParsePeriod(config.DurationSpec)
+		if err == nil {
+			expires = period.AddTo(now, cal)
+		}
+	} else if config.Until != "" {
+		deadline, err := ParseDeadline(config.Until, now.In(cal.Location))
+		if err == nil {
+			expires = deadline
+		}
+	}
+	if approx := expires.Sub(now).Round(time.Second); approx > 0 && !expires.Equal(now.Add(duration)) {
+		// An approximation, the fallback for renewing
+		duration = approx
+	}
+
+	kind := config.Kind
+	if kind == "" {
+		kind = KindFile
+		if isKeyTarget(target) {
+			kind = KindKey
+		}
+	}
+
+	return &ExpirationRecord{
+		Target:       target,
+		Kind:         kind,
+		Expires:      expires,
+		Duration:     duration,
+		DurationSpec: config.DurationSpec,
+		Until:        config.Until,
+		ResetOnTouch: config.ResetOnTouch,
+		Tags:         config.Tags,
+		Action:       config.Action,
+		Created:      now,
+	}
 }
 
 func New(config *NewConfig) error {
@@ -29,9 +135,9 @@ func New(config *NewConfig) error {
 		return err
 	}
 
-	expirationsPath := getExpirationsFilePath(config.GlobalConfig)
+	store := findStore(config.GlobalConfig)
 
-	if expirationsPath == "" {
+	if store == nil {
 		if config.Init {
 			err := Init(&InitConfig{
 				config.GlobalConfig,
@@ -41,50 +147,74 @@ func New(config *NewConfig) error {
 				return err
 			}
 
-			expirationsPath = getExpirationsFilePath(config.GlobalConfig)
+			store = findStore(config.GlobalConfig)
 		}
 	}
 
-	duration := config.Duration
-	if duration == 0 {
-		duration = DefaultDuration()
+	cal, err := loadCalendar(config.GlobalConfig)
+	if err != nil {
+		return err
 	}
-
-	record := &ExpirationRecord{
-		Target:       config.Target,
-		Expires:      time.Now().Add(duration),
-		Duration:     duration,
-		ResetOnTouch: config.ResetOnTouch,
+	targetConfig := config.TargetConfig
+	if config.Kind == KindKey {
+		targetConfig.Targets = withKeyPrefix(config.getTargets())
+	}
+	targets := targetConfig.getTargets()
+	if store != nil {
+		targets, err = targetConfig.canonicalTargets(store.Path())
+		if err != nil {
+			return err
+		}
+	}
+	records := make([]*ExpirationRecord, 0, len(targets))
+	for _, target := range targets {
+		records = append(records, newRecord(config, target, cal))
 	}
 
 	if config.IsDryRun {
-		dryRunReporter.ReportAction("Would insert %#v", record)
+		for _, record := range records {
+			dryRunReporter.ReportAction("Would insert %#v", record)
+		}
 		return nil
 	}
 
-	if expirationsPath == "" {
+	if store == nil {
 		return errors.New("No expirations file. Use init or the init config option to create one")
 	}
 
-	fp := getExpirationsFilePath(config.GlobalConfig)
-	records, err := readRecordsFromFile(fp)
-	if err != nil {
-		return err
-	}
-
-	if config.NoShadow {
-		_, exists := records.getFirst(func(rec ExpirationRecord) bool {
-			return rec.Target == config.Target
-		})
-		if exists {
-			if config.IsBatchRun {
-				return nil
-			} else {
-				return errors.New("A record already exists and 'no shadow' was requested. Bailing.")
+	var errs []error
+	var inserted []ExpirationRecord
+	err = store.Transaction(func(existing *ExpirationRecords) (bool, error) {
+		for _, record := range records {
+			if config.NoShadow {
+				_, exists := existing.getFirst(func(rec ExpirationRecord) bool {
+					return rec.Target == record.Target
+				})
+				if exists {
+					if !config.IsBatchRun {
+						errs = append(errs, errors.New("A record already exists for "+record.Target+" and 'no shadow' was requested. Bailing."))
+					}
+					continue
+				}
+			}
+			_, err := runHooks(config.GlobalConfig, HookPreNew, *record, store.Path())
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if config.Replace {
+				existing.deleteAll(func(rec ExpirationRecord) bool {
+					return rec.Target == record.Target
+				})
 			}
+			existing.insert(record)
+			inserted = append(inserted, *record)
 		}
+		return len(inserted) > 0, nil
+	})
+	if err != nil {
+		return err
 	}
-	records.insert(record)
-
-	return writeRecordsToFile(fp, records)
+	runPostHooks(config.GlobalConfig, HookPostNew, inserted, store.Path())
+	return targetErrors(errs)
 }
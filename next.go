@@ -3,111 +3,78 @@ package expire
 import (
 	"errors"
 	"log"
-	"os"
-	"path/filepath"
-	"regexp"
-
-	"github.com/gobwas/glob"
+	"time"
 )
 
 type NextConfig struct {
 	GlobalConfig
-	Limit      int      // Match no more than this many records
-	Expired    bool     // Match expired records only
-	Delete     bool     // Delete the matched records
-	Exist      bool     // Match records corresponding to files that exist
-	NoExist    bool     // Match records corresponding to files that don't exist
-	MatchGlob  []string // Match according to glob patterns
-	MatchRegex []string // Match according to regex patterns
+	FilterConfig
+	Limit  int  // Match no more than this many records
+	Delete bool // Delete the matched records
 }
 
 func Next(config *NextConfig) ([]*ExpirationRecord, error) {
-
-	if config.Exist && config.NoExist {
-		log.Println("Competing configs set, exist and noexist. Using exist.")
-		config.NoExist = false
-	}
-
-	expirationsPath := getExpirationsFilePath(config.GlobalConfig)
-	if expirationsPath == "" {
-		return nil, errors.New("No expirations file")
-	}
-
-	records, err := readRecordsFromFile(expirationsPath)
+	err := checkFilter(&config.FilterConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	targetToFile := make(map[string]string)
-
-	filtered := records.filter(config.Expired, config.Limit, config.Delete, func(r ExpirationRecord) bool {
-		match := true
-
-		var (
-			fileRelToCurrent string
-			fileExists       bool
-		)
-		absBase, err := filepath.Abs(filepath.Join(expirationsPath, ".."))
-		if err == nil {
-			relToBase := filepath.Join(absBase, r.Target)
-			wd, err := os.Getwd()
-			if err != nil {
-				// idk
-				log.Println("Couldn't get current directory")
-				return true
-			}
-			fileRelToCurrent, err := filepath.Rel(wd, relToBase)
-			if err == nil {
-				fileExists = exists(fileRelToCurrent)
-				if fileExists {
-					targetToFile[r.Target] = relToBase
-				}
-			}
-		}
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		return nil, errors.New("No expirations file")
+	}
 
-		if config.Exist && !fileExists {
-			return false
+	if !config.Delete {
+		records, err := store.Load()
+		if err != nil {
+			return nil, err
 		}
+		return filterRecords(config.FilterConfig, store.Path(), &records, config.Limit)
+	}
 
-		if config.NoExist && fileExists {
-			return false
+	// Expired records have the on-expired hooks run before the pre-delete
+	// ones. Records whose hooks fail are kept, and left out of the result
+	var deleted []*ExpirationRecord
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		filtered, err := filterRecords(config.FilterConfig, store.Path(), records, config.Limit)
+		if err != nil {
+			return false, err
 		}
-
-		if config.MatchGlob != nil {
-			for _, globMatch := range config.MatchGlob {
-				isMatch := glob.MustCompile(globMatch).Match(fileRelToCurrent)
+		now := time.Now()
+		remove := make(map[*ExpirationRecord]bool)
+		for _, rec := range filtered {
+			if !rec.Expires.After(now) {
+				_, err := runHooks(config.GlobalConfig, HookOnExpired, *rec, store.Path())
 				if err != nil {
+					log.Println(err.Error())
 					continue
 				}
-				if !isMatch {
-					match = false
-				}
 			}
+			_, err := runHooks(config.GlobalConfig, HookPreDelete, *rec, store.Path())
+			if err != nil {
+				log.Println(err.Error())
+				continue
+			}
+			remove[rec] = true
+			deleted = append(deleted, rec)
 		}
-		if config.MatchRegex != nil {
-			for _, regexStr := range config.MatchRegex {
-				regex, err := regexp.Compile(regexStr)
-				if err != nil {
-					continue
-				}
-				if !regex.MatchString(r.Target) {
-					match = false
-				}
+		kept := make(ExpirationRecords, 0, len(*records))
+		for _, rec := range *records {
+			if !remove[rec] {
+				kept = append(kept, rec)
 			}
 		}
-		return match
+		*records = kept
+		return len(deleted) > 0, nil
 	})
-
-	for _, rec := range filtered {
-		if val, pres := targetToFile[rec.Target]; pres {
-			rec.targetFilePathAbs = val
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	if config.Delete {
-		return filtered, writeRecordsToFile(expirationsPath, records)
-	} else {
-		return filtered, nil
+	postDelete := make([]ExpirationRecord, 0, len(deleted))
+	for _, rec := range deleted {
+		postDelete = append(postDelete, *rec)
 	}
-
+	runPostHooks(config.GlobalConfig, HookPostDelete, postDelete, store.Path())
+	return deleted, nil
 }
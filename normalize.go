@@ -0,0 +1,51 @@
+package expire
+
+import (
+	"errors"
+)
+
+type NormalizeConfig struct {
+	GlobalConfig
+	DryRunConfig
+	ResolveSymlinks bool // Resolve symlinks in the stored targets too
+}
+
+// Rewrites the targets of existing records in their canonical form, for
+// repos written before targets were canonicalized. Stored targets are taken
+// to be relative to the directory containing the expirations file.
+// Returns the number of records changed.
+func Normalize(config *NormalizeConfig) (int, error) {
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		return 0, errors.New("No expirations file")
+	}
+
+	changed := 0
+	err := store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		for _, rec := range *records {
+			if rec.IsKey() {
+				continue
+			}
+			abs, err := resolveTarget(store.Path(), rec.Target)
+			if err != nil {
+				return false, err
+			}
+			target, err := canonicalTarget(store.Path(), abs, config.ResolveSymlinks)
+			if err != nil {
+				return false, err
+			}
+			if target == rec.Target {
+				continue
+			}
+
+			if config.IsDryRun {
+				dryRunReporter.ReportAction("Will rename %s to %s", rec.Target, target)
+			} else {
+				rec.Target = target
+			}
+			changed++
+		}
+		return changed > 0 && !config.IsDryRun, nil
+	})
+	return changed, err
+}
@@ -0,0 +1,173 @@
+package expire
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Machine-readable output formats
+const (
+	OutputJSON  = "json"  // a JSON array of RecordOutput
+	OutputJSONL = "jsonl" // one RecordOutput JSON object per line
+	OutputCSV   = "csv"   // a header line and one row per record, with the RecordOutput field names
+	OutputTSV   = "tsv"   // like csv, separated by tabs
+	OutputNull  = "null"  // target paths relative to the current directory, each terminated by NUL
+)
+
+func CheckOutputFormat(format string) error {
+	switch format {
+	case OutputJSON, OutputJSONL, OutputCSV, OutputTSV, OutputNull:
+		return nil
+	}
+	return fmt.Errorf("Unknown output format: %s. Should be one of %s, %s, %s, %s or %s",
+		format, OutputJSON, OutputJSONL, OutputCSV, OutputTSV, OutputNull)
+}
+
+// The schema of a record in machine-readable output. Fields are only ever
+// added to it, never changed or removed.
+type RecordOutput struct {
+	Target       string   `json:"target"`       // the target as stored in the repo
+	Path         string   `json:"path"`         // the absolute path of the target
+	Tracked      bool     `json:"tracked"`      // false for targets check found no record for
+	Exists       bool     `json:"exists"`       // whether the path exists
+	Expires      string   `json:"expires"`      // RFC3339, empty if untracked
+	ExpiresIn    int64    `json:"expiresIn"`    // seconds until expiry, negative once expired
+	Expired      bool     `json:"expired"`      // whether the record has expired
+	Duration     string   `json:"duration"`     // the duration or period as written, or in Go syntax
+	ResetOnTouch bool     `json:"resetOnTouch"` // whether touch resets the timer
+	Tags         []string `json:"tags"`         // never null
+	Kind         string   `json:"kind"`         // file, dir or key
+	Action       string   `json:"action"`       // what happens when it expires, see RunExpired
+}
+
+var recordOutputColumns = []string{"target", "path", "tracked", "exists", "expires", "expiresIn", "expired", "duration", "resetOnTouch", "tags", "kind", "action"}
+
+func NewRecordOutput(rec *ExpirationRecord, now time.Time) RecordOutput {
+	duration := rec.DurationSpec
+	if duration == "" {
+		duration = rec.Duration.String()
+	}
+	tags := rec.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return RecordOutput{
+		Target:       rec.Target,
+		Path:         rec.targetFilePathAbs,
+		Tracked:      true,
+		Exists:       rec.targetExists,
+		Expires:      rec.Expires.Format(dateTimeFormat),
+		ExpiresIn:    int64(rec.Expires.Sub(now) / time.Second),
+		Expired:      !rec.Expires.After(now),
+		Duration:     duration,
+		ResetOnTouch: rec.ResetOnTouch,
+		Tags:         tags,
+		Kind:         rec.GetKind(),
+		Action:       rec.GetAction(),
+	}
+}
+
+// The output for a target which has no record
+func NewUntrackedOutput(target string) RecordOutput {
+	if isKeyTarget(target) {
+		return RecordOutput{
+			Target: target,
+			Tags:   []string{},
+			Kind:   KindKey,
+		}
+	}
+	path, err := filepath.Abs(target)
+	if err != nil {
+		path = target
+	}
+	return RecordOutput{
+		Target: target,
+		Path:   path,
+		Exists: exists(path),
+		Tags:   []string{},
+		Kind:   KindFile,
+	}
+}
+
+func (o RecordOutput) values() []string {
+	return []string{
+		o.Target,
+		o.Path,
+		strconv.FormatBool(o.Tracked),
+		strconv.FormatBool(o.Exists),
+		o.Expires,
+		strconv.FormatInt(o.ExpiresIn, 10),
+		strconv.FormatBool(o.Expired),
+		o.Duration,
+		strconv.FormatBool(o.ResetOnTouch),
+		strings.Join(o.Tags, ","),
+		o.Kind,
+		o.Action,
+	}
+}
+
+func WriteOutput(writer io.Writer, format string, outputs []RecordOutput) error {
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(writer)
+		enc.SetIndent("", "  ")
+		if outputs == nil {
+			outputs = []RecordOutput{}
+		}
+		return enc.Encode(outputs)
+	case OutputJSONL:
+		enc := json.NewEncoder(writer)
+		for _, o := range outputs {
+			err := enc.Encode(o)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputCSV, OutputTSV:
+		w := csv.NewWriter(writer)
+		if format == OutputTSV {
+			w.Comma = '\t'
+		}
+		err := w.Write(recordOutputColumns)
+		if err != nil {
+			return err
+		}
+		for _, o := range outputs {
+			err := w.Write(o.values())
+			if err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	case OutputNull:
+		for _, o := range outputs {
+			path := o.Target
+			if o.Path != "" {
+				path = relativeToCwd(o.Path)
+			}
+			_, err := io.WriteString(writer, path+"\x00")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return CheckOutputFormat(format)
+}
+
+func WriteRecordsOutput(writer io.Writer, format string, recs []*ExpirationRecord) error {
+	now := time.Now()
+	outputs := make([]RecordOutput, 0, len(recs))
+	for _, rec := range recs {
+		outputs = append(outputs, NewRecordOutput(rec, now))
+	}
+	return WriteOutput(writer, format, outputs)
+}
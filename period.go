@@ -0,0 +1,251 @@
+package expire
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A length of time measured on the calendar rather than the clock.
+// Years, months and days keep the wall-clock time across DST changes,
+// months are clamped to the end of shorter months (Jan 31 + 1 month is
+// Feb 28), and business days skip weekends and holidays.
+// If EndOf is set, the result is moved to the end of that unit, i.e. the
+// start of the next one.
+type Period struct {
+	Years        int
+	Months       int
+	Days         int
+	BusinessDays int
+	Clock        time.Duration // hours, minutes and seconds
+	EndOf        string        // "", "day", "week", "month", "quarter" or "year"
+}
+
+var endOfRegex = regexp.MustCompile(`^(?:(.*?)\s+)??(?:until\s+)?(?:the\s+)?end\s+of\s+(?:the\s+)?(day|week|month|quarter|year)$`)
+var businessDaysRegex = regexp.MustCompile(`\b(?:business|working|work)\s*days?\b|\bworkdays?\b`)
+
+// Parses a period, e.g. "1 month", "2 business days", "1 week and 3 days",
+// "end of quarter", "1 month until end of month", or any duration
+// ParseDurationString accepts.
+func ParsePeriod(str string) (Period, error) {
+	var p Period
+
+	d, err := time.ParseDuration(str)
+	if err == nil {
+		p.Clock = d
+		return p, nil
+	}
+
+	normalized := strings.ToLower(strings.TrimSpace(str))
+	if m := endOfRegex.FindStringSubmatch(normalized); m != nil {
+		p.EndOf = m[2]
+		normalized = m[1]
+		if normalized == "" {
+			return p, nil
+		}
+	}
+	normalized = businessDaysRegex.ReplaceAllString(normalized, "bd")
+
+	err = parseDurationTerms(normalized, func(amount float64, unitName string) error {
+		whole := amount == math.Trunc(amount)
+		switch unitName {
+		case "y", "yr", "yrs", "year", "years":
+			p.Years += int(amount)
+		case "mo", "month", "months":
+			p.Months += int(amount)
+		case "w", "wk", "wks", "week", "weeks":
+			p.Days += 7 * int(amount)
+		case "d", "day", "days":
+			p.Days += int(amount)
+		case "bd":
+			p.BusinessDays += int(amount)
+		default:
+			unit, ok := durationUnits[unitName]
+			if !ok {
+				return fmt.Errorf("Invalid period: %q. Unknown unit %q", str, unitName)
+			}
+			p.Clock += time.Duration(amount * float64(unit))
+			return nil
+		}
+		if !whole {
+			return fmt.Errorf("Invalid period: %q. Use whole numbers of %s", str, unitName)
+		}
+		return nil
+	})
+	if err != nil {
+		return Period{}, err
+	}
+	return p, nil
+}
+
+// Parses the period, which must move now forward
+func checkPeriod(str string, now time.Time, cal *Calendar) error {
+	period, err := ParsePeriod(str)
+	if err != nil {
+		return err
+	}
+	if !period.AddTo(now, cal).After(now) {
+		return fmt.Errorf("Invalid duration: %q. It must be positive", str)
+	}
+	return nil
+}
+
+// Adds the period to t, in the calendar's time zone
+func (p Period) AddTo(t time.Time, cal *Calendar) time.Time {
+	t = t.In(cal.Location)
+
+	if p.Years != 0 || p.Months != 0 {
+		t = addMonthsClamped(t, p.Years*12+p.Months)
+	}
+	if p.Days != 0 {
+		t = t.AddDate(0, 0, p.Days)
+	}
+	for i := 0; i < p.BusinessDays; i++ {
+		t = t.AddDate(0, 0, 1)
+		for !cal.IsBusinessDay(t) {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	t = t.Add(p.Clock)
+
+	if p.EndOf != "" {
+		t = endOf(t, p.EndOf)
+	}
+	return t
+}
+
+// Like AddDate, but the day is clamped to the length of the month
+func addMonthsClamped(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	first = first.AddDate(0, months, 0)
+	day := t.Day()
+	if last := daysIn(first.Year(), first.Month()); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// The start of the next day, week (starting monday), month, quarter or year
+func endOf(t time.Time, unit string) time.Time {
+	y, m, d := t.Date()
+	loc := t.Location()
+	switch unit {
+	case "day":
+		return time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	case "week":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-daysSinceMonday+7, 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+	case "quarter":
+		quarterStart := time.Month((int(m)-1)/3*3 + 1)
+		return time.Date(y, quarterStart+3, 1, 0, 0, 0, 0, loc)
+	case "year":
+		return time.Date(y+1, time.January, 1, 0, 0, 0, 0, loc)
+	}
+	return t
+}
+
+func (p Period) String() string {
+	parts := make([]string, 0)
+	add := func(n int, unit string) {
+		if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, unit))
+		}
+	}
+	add(p.Years, "years")
+	add(p.Months, "months")
+	add(p.Days, "days")
+	add(p.BusinessDays, "business days")
+	if p.Clock != 0 {
+		parts = append(parts, p.Clock.String())
+	}
+	if p.EndOf != "" {
+		parts = append(parts, "until end of "+p.EndOf)
+	}
+	return strings.Join(parts, " ")
+}
+
+// The time zone and holidays periods are evaluated in
+type Calendar struct {
+	Location *time.Location
+	Holidays map[string]bool // dates formatted as 2006-01-02
+}
+
+const holidayDateFormat = "2006-01-02"
+
+func (c *Calendar) IsBusinessDay(t time.Time) bool {
+	t = t.In(c.Location)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !c.Holidays[t.Format(holidayDateFormat)]
+}
+
+// Loads the calendar from the time zone and holidays file in the config,
+// falling back to EXPIRE_TZ and EXPIRE_HOLIDAYS
+func loadCalendar(config GlobalConfig) (*Calendar, error) {
+	cal := &Calendar{
+		Location: time.Local,
+		Holidays: make(map[string]bool),
+	}
+
+	timeZone := config.TimeZone
+	if timeZone == "" {
+		timeZone = os.Getenv("EXPIRE_TZ")
+	}
+	if timeZone != "" {
+		loc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time zone %q: %s", timeZone, err)
+		}
+		cal.Location = loc
+	}
+
+	holidaysFile := config.HolidaysFile
+	if holidaysFile == "" {
+		holidaysFile = os.Getenv("EXPIRE_HOLIDAYS")
+	}
+	if holidaysFile != "" {
+		err := readHolidays(holidaysFile, cal.Holidays)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cal, nil
+}
+
+// One date per line as 2006-01-02. Blank lines and lines starting with #
+// are ignored, as is anything after the date.
+func readHolidays(holidaysFile string, holidays map[string]bool) error {
+	f, err := os.Open(holidaysFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		date, err := time.Parse(holidayDateFormat, fields[0])
+		if err != nil {
+			return fmt.Errorf("Error parsing holidays file %s (line #%d): %s", holidaysFile, lineNum, err)
+		}
+		holidays[date.Format(holidayDateFormat)] = true
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,96 @@
+package expire
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    Period
+		wantErr bool
+	}{
+		{str: "10m", want: Period{Clock: 10 * time.Minute}},
+		{str: "-5m", want: Period{Clock: -5 * time.Minute}},
+		{str: "1 month", want: Period{Months: 1}},
+		{str: "1 year and 2 months", want: Period{Years: 1, Months: 2}},
+		{str: "1 week and 3 days", want: Period{Days: 10}},
+		{str: "2 business days", want: Period{BusinessDays: 2}},
+		{str: "3 working days", want: Period{BusinessDays: 3}},
+		{str: "1 workday", want: Period{BusinessDays: 1}},
+		{str: "1 day 6 hours", want: Period{Days: 1, Clock: 6 * time.Hour}},
+		{str: "end of quarter", want: Period{EndOf: "quarter"}},
+		{str: "end of the week", want: Period{EndOf: "week"}},
+		{str: "the end of the week", want: Period{EndOf: "week"}},
+		{str: "until the end of the month", want: Period{EndOf: "month"}},
+		{str: "2 days until the end of the day", want: Period{Days: 2, EndOf: "day"}},
+		{str: "1 month until end of month", want: Period{Months: 1, EndOf: "month"}},
+		{str: "0 days", want: Period{}},
+		{str: "", wantErr: true},
+		{str: "1.5 months", wantErr: true},
+		{str: "3 sprints", wantErr: true},
+		{str: "end of time", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParsePeriod(test.str)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParsePeriod(%q) = %+v, expected an error", test.str, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePeriod(%q) failed: %s", test.str, err)
+		} else if got != test.want {
+			t.Errorf("ParsePeriod(%q) = %+v, expected %+v", test.str, got, test.want)
+		}
+	}
+}
+
+func TestPeriodAddTo(t *testing.T) {
+	cal := &Calendar{
+		Location: time.UTC,
+		Holidays: map[string]bool{"2026-10-19": true},
+	}
+	tests := []struct {
+		str  string
+		from time.Time
+		want time.Time
+	}{
+		// Clamped to the end of february
+		{str: "1 month", from: time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC), want: time.Date(2026, 2, 28, 9, 0, 0, 0, time.UTC)},
+		// Friday, over the weekend and the holiday on monday
+		{str: "1 business day", from: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)},
+		{str: "end of quarter", from: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC), want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Wednesday, to the next monday
+		{str: "end of week", from: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		period, err := ParsePeriod(test.str)
+		if err != nil {
+			t.Errorf("ParsePeriod(%q) failed: %s", test.str, err)
+			continue
+		}
+		if got := period.AddTo(test.from, cal); !got.Equal(test.want) {
+			t.Errorf("%q from %s = %s, expected %s", test.str, test.from, got, test.want)
+		}
+	}
+}
+
+func TestCheckPeriod(t *testing.T) {
+	cal := &Calendar{Location: time.UTC, Holidays: map[string]bool{}}
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+	for _, str := range []string{"-5m", "0s", "0 days", "-1 month", "1 day and -1 day"} {
+		err := checkPeriod(str, now, cal)
+		if err == nil {
+			t.Errorf("checkPeriod(%q) accepted a period which doesn't move forward", str)
+		}
+	}
+	for _, str := range []string{"1s", "1 month", "2 business days", "end of day"} {
+		err := checkPeriod(str, now, cal)
+		if err != nil {
+			t.Errorf("checkPeriod(%q) failed: %s", str, err)
+		}
+	}
+}
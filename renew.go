@@ -9,17 +9,41 @@ type RenewConfig struct {
 	BatchRunConfig
 	DryRunConfig
 	TargetConfig
+	Until string // Expire at this deadline from now on, see ParseDeadline
 }
 
 func Renew(config *RenewConfig) error {
+	cal, err := loadCalendar(config.GlobalConfig)
+	if err != nil {
+		return err
+	}
+
+	if config.Until != "" {
+		_, err := parseFutureDeadline(config.Until, time.Now().In(cal.Location))
+		if err != nil {
+			return err
+		}
+	}
+
 	return Update(&UpdateConfig{
 		config.GlobalConfig,
 		config.BatchRunConfig,
 		config.DryRunConfig,
 		config.TargetConfig,
+		"renew",
 	}, func(rec *ExpirationRecord) {
-		// renew this record: remake it with the same settings
-		// i.e. simply reset the timer
-		rec.Expires = time.Now().Add(rec.Duration)
+		if config.Until != "" {
+			rec.Until = config.Until
+		}
+		renewRecord(rec, cal)
 	})
 }
+
+// renew this record: remake it with the same settings
+// i.e. simply reset the timer
+func renewRecord(rec *ExpirationRecord, cal *Calendar) {
+	now := time.Now()
+	rec.Expires = rec.nextExpiration(now, cal)
+	rec.LastRenewed = now
+	rec.RenewCount++
+}
@@ -0,0 +1,177 @@
+package expire
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type RestoreConfig struct {
+	GlobalConfig
+	DryRunConfig
+	TargetConfig
+}
+
+func checkRestore(config *RestoreConfig) error {
+	if len(config.getTargets()) == 0 {
+		return errors.New("No target")
+	}
+	return nil
+}
+
+// Moves trashed targets back to where they were, or unpacks archived ones,
+// and re-creates their records from the trashinfo or archive, renewed from
+// now. If a target was trashed or archived more than once, the most recent
+// one is restored, looking in the trash first.
+func Restore(config *RestoreConfig) error {
+	err := checkRestore(config)
+	if err != nil {
+		return err
+	}
+
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		return errors.New("No expirations file")
+	}
+
+	targets, err := config.canonicalTargets(store.Path())
+	if err != nil {
+		return err
+	}
+
+	cal, err := loadCalendar(config.GlobalConfig)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		restored := false
+		for _, target := range targets {
+			rec, err := restore(config, store, target)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if rec != nil {
+				renewRecord(rec, cal)
+				records.insert(rec)
+				restored = true
+			}
+		}
+		return restored, nil
+	})
+	if err != nil {
+		return err
+	}
+	return targetErrors(errs)
+}
+
+// Restores a single target from the trash or an archive, returning its record as it was when it was
+// trashed. The record is nil on a dry run.
+func restore(config *RestoreConfig, store Store, target string) (*ExpirationRecord, error) {
+	absExpirationsPath, err := filepath.Abs(store.Path())
+	if err != nil {
+		return nil, err
+	}
+	targetPath, err := resolveTarget(store.Path(), target)
+	if err != nil {
+		return nil, err
+	}
+	trashDirs, err := trashDirsFor(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := findTrashed(trashDirs, absExpirationsPath, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return restoreArchive(config, store, target)
+	}
+	entry := entries[0]
+
+	var jr jsonlRecord
+	err = json.Unmarshal([]byte(entry.info["X-Expire-Record"]), &jr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid record in %s: %s", entry.infoPath, err)
+	}
+	rec, err := fromJSONLRecord(jr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid record in %s: %s", entry.infoPath, err)
+	}
+
+	originalPath, err := entry.originalPath()
+	if err != nil {
+		return nil, err
+	}
+	if exists(originalPath) {
+		return nil, fmt.Errorf("Can't restore %s: %s already exists", target, originalPath)
+	}
+
+	if config.IsDryRun {
+		dryRunReporter.ReportAction("Will restore %s to %s", entry.filesPath, originalPath)
+		dryRunReporter.ReportAction("Will re-create record: %s", target)
+		return nil, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(originalPath), 0777)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(entry.filesPath, originalPath)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Remove(entry.infoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// It goes back as the most recent record for the target
+	rec.Seq = 0
+	return rec, nil
+}
+
+func restoreArchive(config *RestoreConfig, store Store, target string) (*ExpirationRecord, error) {
+	archives, err := findArchives(config.GlobalConfig, store.Path(), target)
+	if err != nil {
+		return nil, err
+	}
+	if len(archives) == 0 {
+		return nil, errors.New("Not in the trash or archived: " + target)
+	}
+	archivePath := archives[0]
+
+	targetPath, err := resolveTarget(store.Path(), target)
+	if err != nil {
+		return nil, err
+	}
+	if exists(targetPath) {
+		return nil, fmt.Errorf("Can't restore %s: %s already exists", target, targetPath)
+	}
+
+	if config.IsDryRun {
+		dryRunReporter.ReportAction("Will unpack %s to %s", archivePath, targetPath)
+		dryRunReporter.ReportAction("Will re-create record: %s", target)
+		return nil, nil
+	}
+
+	metadata, err := extractArchive(archivePath, store.Path())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unpack %s: %s", archivePath, err)
+	}
+	rec, err := fromJSONLRecord(metadata.Record)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid record in %s: %s", archivePath, err)
+	}
+	err = os.Remove(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rec.Seq = 0
+	return rec, nil
+}
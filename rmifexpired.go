@@ -0,0 +1,145 @@
+package expire
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+type RmIfExpiredConfig struct {
+	GlobalConfig
+	BatchRunConfig
+	DryRunConfig
+	TargetConfig
+	Trash bool // Move the files to the trash instead of removing them
+}
+
+func checkRmIfExpired(config *RmIfExpiredConfig) error {
+	if len(config.getTargets()) == 0 {
+		return errors.New("No target")
+	}
+	return nil
+}
+
+// Ensures that if a target is tracked and expired, its record is deleted
+// and the file it refers to is removed.
+// Unexpired targets are left alone.
+// The on-expired and pre-delete hooks run before each removal, which is
+// skipped if they fail, and the post-delete hooks run after.
+func RmIfExpired(config *RmIfExpiredConfig) error {
+	err := checkRmIfExpired(config)
+	if err != nil {
+		return err
+	}
+
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		if config.IsBatchRun {
+			return nil
+		} else {
+			return errors.New("No expirations file")
+		}
+	}
+
+	targets, err := config.canonicalTargets(store.Path())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	var deleted []ExpirationRecord
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		for _, target := range targets {
+			rec, ok, err := rmIfExpired(config, store, records, target)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if ok {
+				deleted = append(deleted, rec)
+			}
+		}
+		return len(deleted) > 0 && !config.IsDryRun, nil
+	})
+	if err != nil {
+		return err
+	}
+	if !config.IsDryRun {
+		runPostHooks(config.GlobalConfig, HookPostDelete, deleted, store.Path())
+	}
+	return targetErrors(errs)
+}
+
+// Removes a single target from records and from disk if it has expired.
+// Returns the record if it was removed.
+func rmIfExpired(config *RmIfExpiredConfig, store Store, records *ExpirationRecords, target string) (ExpirationRecord, bool, error) {
+	rec, present := records.getFirst(func(rec ExpirationRecord) bool {
+		return rec.Target == target
+	})
+
+	if !present {
+		if config.IsDryRun {
+			dryRunReporter.ReportAction("Will not remove untracked target: %s", target)
+		}
+		if config.IsBatchRun {
+			return ExpirationRecord{}, false, nil
+		} else {
+			return ExpirationRecord{}, false, errors.New("No such record: " + target)
+		}
+	}
+
+	if rec.IsKey() {
+		// There is nothing to remove, and the record is how the key expires
+		if config.IsDryRun {
+			dryRunReporter.ReportAction("Will not remove key target: %s", target)
+		}
+		return ExpirationRecord{}, false, nil
+	}
+
+	if rec.Expires.After(time.Now()) {
+		if config.IsDryRun {
+			dryRunReporter.ReportAction("Will not remove unexpired target: %s", target)
+		}
+		return ExpirationRecord{}, false, nil
+	}
+
+	targetPath, err := resolveTarget(store.Path(), rec.Target)
+	if err != nil {
+		return ExpirationRecord{}, false, err
+	}
+
+	if config.IsDryRun {
+		dryRunReporter.ReportAction("Will delete record: %s", target)
+		if exists(targetPath) && config.Trash {
+			dryRunReporter.ReportAction("Will trash the file: %s", targetPath)
+		} else if exists(targetPath) {
+			dryRunReporter.ReportAction("Will remove the file: %s", targetPath)
+		}
+		return rec, true, nil
+	}
+
+	rec.targetFilePathAbs = targetPath
+	_, err = runHooks(config.GlobalConfig, HookOnExpired, rec, store.Path())
+	if err != nil {
+		return ExpirationRecord{}, false, err
+	}
+	_, err = runHooks(config.GlobalConfig, HookPreDelete, rec, store.Path())
+	if err != nil {
+		return ExpirationRecord{}, false, err
+	}
+
+	if config.Trash {
+		err = trashTarget(&rec, store.Path())
+	} else if rec.GetKind() == KindDir {
+		err = os.RemoveAll(targetPath)
+	} else {
+		err = os.Remove(targetPath)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return ExpirationRecord{}, false, err
+	}
+
+	records.deleteFirst(func(rec ExpirationRecord) bool {
+		return rec.Target == target
+	})
+	return rec, true, nil
+}
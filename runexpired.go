@@ -0,0 +1,159 @@
+package expire
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RunExpiredConfig struct {
+	GlobalConfig
+	DryRunConfig
+	// Records to leave alone, e.g. while the daemon backs off from them
+	skip func(ExpirationRecord) bool
+}
+
+// The outcome of running the action of one expired record
+type ActionResult struct {
+	Record  *ExpirationRecord
+	Outcome ActionOutcome
+	Err     error // why the action failed
+}
+
+// Serializes runs within the process, so that a claim held by this process
+// is always left over from a run which was cut short
+var runExpiredMu sync.Mutex
+
+// Identifies this process in claims, as host:pid
+func claimID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// Whether the record is claimed by a process which may still be running its
+// action. Claims from other hosts can't be checked, so they are assumed to
+// be live.
+func (r ExpirationRecord) isClaimed() bool {
+	if r.ClaimedBy == "" {
+		return false
+	}
+	i := strings.LastIndex(r.ClaimedBy, ":")
+	if i == -1 {
+		return false
+	}
+	host, _ := os.Hostname()
+	if r.ClaimedBy[:i] != host {
+		return true
+	}
+	pid, err := strconv.Atoi(r.ClaimedBy[i+1:])
+	if err != nil || pid == os.Getpid() {
+		return false
+	}
+	return processExists(pid)
+}
+
+// Runs the action of every expired record which has one.
+// The records are first claimed in one transaction, by saving them marked
+// with the process running their actions (ClaimedBy), so that concurrent
+// runs skip them. The actions run without holding the lock, and after each
+// one the record is deleted, renewed if the action asked for it, or has its
+// claim cleared if it failed, in a transaction of its own. A run which is cut
+// short leaves its remaining records claimed, and they are claimed again
+// once the process which claimed them is gone.
+// The on-expired and pre-delete hooks run before each action, which fails
+// if they do, and the post-delete hooks run once a record has been deleted.
+// Shadowed records are left alone.
+func RunExpired(config *RunExpiredConfig) ([]ActionResult, error) {
+	store := findStore(config.GlobalConfig)
+	if store == nil {
+		return nil, errors.New("No expirations file")
+	}
+
+	cal, err := loadCalendar(config.GlobalConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	runExpiredMu.Lock()
+	defer runExpiredMu.Unlock()
+
+	claim := claimID()
+	var claimed []*ExpirationRecord
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		shadowed := records.shadowed()
+		now := time.Now()
+		for _, rec := range *records {
+			if !shadowed[rec] && rec.GetAction() != ActionNone && !rec.Expires.After(now) && !rec.isClaimed() && (config.skip == nil || !config.skip(*rec)) {
+				claimed = append(claimed, rec)
+			}
+		}
+		if config.IsDryRun {
+			for _, rec := range claimed {
+				dryRunReporter.ReportAction("Would run %s for %s", rec.GetAction(), rec.Target)
+			}
+			return false, nil
+		}
+		for _, rec := range claimed {
+			rec.ClaimedBy = claim
+		}
+		return len(claimed) > 0, nil
+	})
+	if err != nil || config.IsDryRun || len(claimed) == 0 {
+		return nil, err
+	}
+
+	results := make([]ActionResult, 0, len(claimed))
+	for _, rec := range claimed {
+		result := runClaimedAction(config.GlobalConfig, rec, store.Path())
+		results = append(results, result)
+
+		isClaimed := func(r ExpirationRecord) bool {
+			return r.Target == rec.Target && r.ClaimedBy == claim
+		}
+		deleted := false
+		err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+			if result.Outcome == ActionDone {
+				_, deleted = records.deleteFirst(isClaimed)
+				return deleted, nil
+			}
+			return records.updateFirst(isClaimed, func(r *ExpirationRecord) {
+				if result.Outcome == ActionRenewed {
+					renewRecord(r, cal)
+				}
+				r.ClaimedBy = ""
+			}), nil
+		})
+		if err != nil {
+			return results, err
+		}
+		rec.ClaimedBy = ""
+		if deleted {
+			runPostHooks(config.GlobalConfig, HookPostDelete, []ExpirationRecord{*rec}, store.Path())
+		}
+	}
+	return results, nil
+}
+
+// Runs the on-expired and pre-delete hooks and then the action of a claimed
+// record
+func runClaimedAction(config GlobalConfig, rec *ExpirationRecord, expirationsPath string) ActionResult {
+	if !rec.IsKey() {
+		var err error
+		rec.targetFilePathAbs, err = resolveTarget(expirationsPath, rec.Target)
+		if err != nil {
+			return ActionResult{rec, ActionFailed, err}
+		}
+	}
+	for _, event := range []string{HookOnExpired, HookPreDelete} {
+		_, err := runHooks(config, event, *rec, expirationsPath)
+		if err != nil {
+			return ActionResult{rec, ActionFailed, err}
+		}
+	}
+	outcome, err := runAction(config, rec, expirationsPath)
+	return ActionResult{rec, outcome, err}
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package expire
+
+import (
+	"os"
+)
+
+// Whether a process with the pid is running, as far as the platform can
+// tell: finding a process fails on Windows once it has exited, but always
+// succeeds elsewhere.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package expire
+
+import (
+	"syscall"
+)
+
+// Whether a process with the pid is running. Signal 0 only checks that it
+// could be signalled.
+func processExists(pid int) bool {
+	return syscall.Kill(pid, 0) != syscall.ESRCH
+}
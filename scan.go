@@ -1,10 +1,8 @@
 package expire
 
 import (
-	"fmt"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"time"
 
@@ -14,8 +12,8 @@ import (
 
 type ScanConfig struct {
 	GlobalConfig
-	ForceRecursive bool
-	Exclude        []string
+	ForceRecursive bool     // Recurse into subdirectories to find more repos
+	Exclude        []string // Don't recurse into directories matching these glob patterns
 }
 
 func createDirectoryMatcher(config ScanConfig) (func(name string) bool, error) {
@@ -29,7 +27,7 @@ func createDirectoryMatcher(config ScanConfig) (func(name string) bool, error) {
 	}
 	return func(name string) bool {
 		for _, g := range globs {
-			if g.Match(name) {
+			if g.Match(name) || g.Match(filepath.Base(name)) {
 				return false
 			}
 		}
@@ -37,56 +35,125 @@ func createDirectoryMatcher(config ScanConfig) (func(name string) bool, error) {
 	}, nil
 }
 
-func doExpiredAction(config ScanConfig, info os.FileInfo, record *ExpirationRecord) {
-	fmt.Printf("%s\t%s", info.Name(), record.Target)
-}
-
-func scan(config ScanConfig, info os.FileInfo) error {
-	records, err := readRecordsFromFile(info.Name())
+// Returns the expired records of a single repo, resolved against the
+// directory of the expirations file. Shadowed records are skipped, and so
+// are keys, which have no file to clean up.
+func scan(store Store) ([]*ExpirationRecord, error) {
+	records, err := store.Load()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	shadowed := records.shadowed()
+	expired := make([]*ExpirationRecord, 0)
 	for _, record := range records {
+		if shadowed[record] || record.IsKey() {
+			continue
+		}
 		if record.Expires.Before(time.Now()) {
-			doExpiredAction(config, info, record)
+			record.targetFilePathAbs, err = resolveTarget(store.Path(), record.Target)
+			if err != nil {
+				return nil, err
+			}
+			record.targetExists = exists(record.targetFilePathAbs)
+			expired = append(expired, record)
 		}
 	}
-	return nil
+	return expired, nil
 }
 
-func Scan(config ScanConfig) error {
+// Finds the repos to scan: the current repo, and if ForceRecursive is set,
+// every repo beneath the current directory
+func findRepos(config ScanConfig) ([]Store, error) {
+	repos := make([]Store, 0)
+	seen := make(map[string]bool)
+	addRepo := func(store Store) {
+		abs, err := filepath.Abs(store.Path())
+		if err != nil {
+			log.Printf("Failed to resolve %s: %s", store.Path(), err.Error())
+			return
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			repos = append(repos, store)
+		}
+	}
+
+	current := findStore(config.GlobalConfig)
+	if current != nil {
+		addRepo(current)
+	}
+
+	if !config.ForceRecursive || config.Store != nil {
+		return repos, nil
+	}
+
 	matcher, err := createDirectoryMatcher(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	cwd, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("Failed to get working dir: %s", err)
+		return nil, errors.Wrap(err, "Failed to get working dir")
 	}
 
-	e := filepath.Walk(cwd, func(p string, info os.FileInfo, err error) error {
-
-		if info.IsDir() {
-			if !matcher(p) {
+	err = filepath.Walk(cwd, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Failed to traverse %s: %s", p, err.Error())
+			if info != nil && info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		if path.Base(info.Name()) == config.getFileName() {
-			err := scan(config, info)
+		if info.IsDir() {
+			if p == cwd {
+				return nil
+			}
+			rel, err := filepath.Rel(cwd, p)
 			if err != nil {
-				log.Printf("Failed to scan %s: %s", info.Name(), err.Error())
+				rel = p
+			}
+			if !matcher(rel) {
+				return filepath.SkipDir
 			}
+			return nil
+		}
+
+		if info.Name() == config.getFileName() {
+			addRepo(newFileStore(p, config.GlobalConfig))
 		}
 
 		return nil
 	})
 
 	if err != nil {
-		return errors.Wrap(e, "Failed to traverse directory")
+		return nil, errors.Wrap(err, "Failed to traverse directory")
+	}
+	return repos, nil
+}
+
+// Returns the expired records of the current repo, and if ForceRecursive is
+// set, of every repo beneath the current directory.
+// Returns an error if no repo is found.
+func Scan(config ScanConfig) ([]*ExpirationRecord, error) {
+	repos, err := findRepos(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(repos) == 0 {
+		return nil, errors.New("No expirations file")
 	}
-	return nil
 
+	expired := make([]*ExpirationRecord, 0)
+	for _, repo := range repos {
+		recs, err := scan(repo)
+		if err != nil {
+			log.Printf("Failed to scan %s: %s", repo.Path(), err.Error())
+			continue
+		}
+		expired = append(expired, recs...)
+	}
+	return expired, nil
 }
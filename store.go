@@ -1,33 +1,149 @@
 package expire
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const dateTimeFormat string = time.RFC3339
 
+// A Store persists the records of a single repo
+type Store interface {
+	// The location of the repo. Targets are relative to its directory.
+	Path() string
+	Load() (ExpirationRecords, error)
+	Save(records ExpirationRecords) error
+	// Performs a read-modify-write, excluding any other writers.
+	// The records are only saved if fn returns true.
+	Transaction(fn func(records *ExpirationRecords) (bool, error)) error
+	// Deletes the repo entirely. Safe to call within a transaction.
+	Remove() error
+}
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// How records are encoded in an expirations file
+type recordFormat struct {
+	read  func(reader io.Reader) (ExpirationRecords, error)
+	write func(writer io.Writer, recs ExpirationRecords) error
+}
+
+var recordFormats = map[string]recordFormat{
+	FormatCSV:   {readRecords, writeRecords},
+	FormatJSONL: {readJSONLRecords, writeJSONLRecords},
+}
+
+func checkFormat(format string) error {
+	if _, ok := recordFormats[format]; !ok {
+		return fmt.Errorf("Unknown format: %s. Should be %s or %s", format, FormatCSV, FormatJSONL)
+	}
+	return nil
+}
+
+// JSON Lines files always start with the header object, anything else is CSV
+func detectFormat(content []byte) string {
+	trimmed := bytes.TrimLeft(content, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return FormatJSONL
+	}
+	return FormatCSV
+}
+
+// Locates the store for the current repo, or nil if there is none
+func findStore(config GlobalConfig) Store {
+	if config.Store != nil {
+		return config.Store
+	}
+	expirationsPath := getExpirationsFilePath(config)
+	if expirationsPath == "" {
+		return nil
+	}
+	return newFileStore(expirationsPath, config)
+}
+
+// CSV files may start with a marker line giving the version of the format,
+// i.e. the minimum version of expire which can read it. No marker means 1.
+const csvVersionMarker = "#version="
+const csvFormatVersion = 1
+
+// Columns are mapped by their header name. Unknown columns are preserved.
+var csvColumns = []string{"target", "kind", "expires", "duration", "durationSpec", "until", "resetOnTouch", "tags", "action", "claimedBy", "created", "lastTouched", "lastRenewed", "notified", "renewCount", "seq"}
+var requiredCSVColumns = []string{"target", "expires"}
+
+func readCSVVersion(reader *bufio.Reader) (int, error) {
+	peeked, _ := reader.Peek(len(csvVersionMarker))
+	if string(peeked) != csvVersionMarker {
+		return 1, nil
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, csvVersionMarker)))
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing expirations file version: %s", err)
+	}
+	return version, nil
+}
+
 func readRecords(reader io.Reader) (ExpirationRecords, error) {
-	r := csv.NewReader(reader)
+	br := bufio.NewReader(reader)
+	version, err := readCSVVersion(br)
+	if err != nil {
+		return nil, err
+	}
+	if version > csvFormatVersion {
+		return nil, fmt.Errorf("Expirations file was written by a newer version of expire (format version %d, supported up to %d)", version, csvFormatVersion)
+	}
+
+	r := csv.NewReader(br)
 	records, err := r.ReadAll()
 	if err != nil {
 		// IO / CSV parse error?
 		return nil, err
 	}
 	out := make(ExpirationRecords, 0, len(records))
-	for i, r := range records {
-		if i == 0 {
-			// Skip the header
-			continue
+	if len(records) == 0 {
+		return out, nil
+	}
+
+	header := records[0]
+	for _, required := range requiredCSVColumns {
+		found := false
+		for _, name := range header {
+			if name == required {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Error parsing expirations file: missing the %s column", required)
 		}
-		rec, err := fromRecord(r)
+	}
+
+	for i, r := range records[1:] {
+		values := make(map[string]string, len(header))
+		for col, name := range header {
+			values[name] = r[col]
+		}
+		rec, err := fromRecord(values)
 		if err != nil {
 			// malformed record?
-			return nil, errors.New(fmt.Sprintf("Error parsing expirations file (line #%d): %s", i, err))
+			return nil, errors.New(fmt.Sprintf("Error parsing expirations file (line #%d): %s", i+1, err))
 		}
 
 		out = append(out, rec)
@@ -36,12 +152,39 @@ func readRecords(reader io.Reader) (ExpirationRecords, error) {
 }
 
 func writeRecords(writer io.Writer, recs ExpirationRecords) error {
+	_, err := fmt.Fprintf(writer, "%s%d\n", csvVersionMarker, csvFormatVersion)
+	if err != nil {
+		return err
+	}
+
 	w := csv.NewWriter(writer)
 
-	w.Write([]string{"target", "expires", "duration", "resetOnTouch"})
+	// Unknown columns read from the file go after the known ones
+	extraColumns := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, r := range recs {
+		for name := range r.extra {
+			if !seen[name] {
+				seen[name] = true
+				extraColumns = append(extraColumns, name)
+			}
+		}
+	}
+	sort.Strings(extraColumns)
+	header := append(append([]string{}, csvColumns...), extraColumns...)
+
+	err = w.Write(header)
+	if err != nil {
+		return err
+	}
 
 	for _, r := range recs {
-		err := w.Write(toRecord(*r))
+		values := toRecord(*r)
+		row := make([]string, len(header))
+		for col, name := range header {
+			row[col] = values[name]
+		}
+		err := w.Write(row)
 		if err != nil {
 			return err
 		}
@@ -49,74 +192,252 @@ func writeRecords(writer io.Writer, recs ExpirationRecords) error {
 
 	w.Flush()
 
-	return nil
+	return w.Error()
 }
 
-func readRecordsFromFile(expirationsFile string) (ExpirationRecords, error) {
-	f, err := os.Open(expirationsFile)
-	defer f.Close()
+// Reads the records in whichever format the file is in, which is returned
+func readRecordsFromFile(expirationsFile string) (ExpirationRecords, string, error) {
+	content, err := ioutil.ReadFile(expirationsFile)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	recs, err := readRecords(f)
+	format := detectFormat(content)
+	recs, err := recordFormats[format].read(bytes.NewReader(content))
+	return recs, format, err
+}
+
+// Writes the records to a temporary file in the same directory, and renames
+// it over the expirations file, so that a crash never leaves it half written.
+// The mode of the original file is preserved, and if it is a symlink the
+// file it points to is replaced instead.
+func writeRecordsToFile(expirationsFile string, format string, records ExpirationRecords) error {
+	err := checkFormat(format)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return recs, f.Sync()
-}
 
-func writeRecordsToFile(expirationsFile string, records ExpirationRecords) error {
-	f, err := os.Create(expirationsFile)
+	resolved, err := filepath.EvalSymlinks(expirationsFile)
+	if err == nil {
+		expirationsFile = resolved
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	var mode os.FileMode = 0666
+	info, err := os.Stat(expirationsFile)
+	if err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir, base := filepath.Split(expirationsFile)
+	if dir == "" {
+		dir = "."
+	}
+	f, tmpPath, err := createTempFile(dir, base, mode)
 	if err != nil {
 		return err
 	}
-	err = writeRecords(f, records)
+	committed := false
+	defer func() {
+		if !committed {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if info != nil {
+		// The umask may have stripped some permissions when creating
+		err = f.Chmod(mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordFormats[format].write(f, records)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
 	if err != nil {
 		return err
 	}
-	return f.Sync()
-}
 
-func fromRecord(r []string) (*ExpirationRecord, error) {
+	err = os.Rename(tmpPath, expirationsFile)
+	if err != nil {
+		return err
+	}
+	committed = true
 
-	if len(r) != 4 {
-		return nil, errors.New("Incorrect length record. Should be 4")
+	return syncDir(dir)
+}
+
+// Like os.CreateTemp, but respects the umask for the given mode
+func createTempFile(dir string, base string, mode os.FileMode) (*os.File, string, error) {
+	for i := 0; ; i++ {
+		tmpPath := filepath.Join(dir, fmt.Sprintf(".%s.tmp-%d-%d", base, os.Getpid(), rand.Int63()))
+		f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, tmpPath, err
 	}
+}
 
-	expires, err := time.Parse(dateTimeFormat, r[1])
+// Ensures a rename in the directory is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer d.Close()
+	return d.Sync()
+}
 
-	duration, err := ParseDurationString(r[2])
+func fromRecord(values map[string]string) (*ExpirationRecord, error) {
+	expires, err := time.Parse(dateTimeFormat, values["expires"])
 	if err != nil {
 		return nil, err
 	}
 
+	duration := DefaultDuration()
+	if values["duration"] != "" {
+		duration, err = ParseDurationString(values["duration"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	resetOnTouch := false
-	if r[3] == "yes" {
+	if values["resetOnTouch"] == "yes" {
 		resetOnTouch = true
 	}
 
+	created, err := parseOptionalTime(values["created"])
+	if err != nil {
+		return nil, err
+	}
+	lastTouched, err := parseOptionalTime(values["lastTouched"])
+	if err != nil {
+		return nil, err
+	}
+	lastRenewed, err := parseOptionalTime(values["lastRenewed"])
+	if err != nil {
+		return nil, err
+	}
+	notified, err := parseOptionalTime(values["notified"])
+	if err != nil {
+		return nil, err
+	}
+
+	renewCount := 0
+	if values["renewCount"] != "" {
+		renewCount, err = strconv.Atoi(values["renewCount"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seq := 0
+	if values["seq"] != "" {
+		seq, err = strconv.Atoi(values["seq"])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tags []string
+	if values["tags"] != "" {
+		tags = strings.Split(values["tags"], ",")
+	}
+
+	var extra map[string]string
+	for name, value := range values {
+		if !isCSVColumn(name) {
+			if extra == nil {
+				extra = make(map[string]string)
+			}
+			extra[name] = value
+		}
+	}
+
 	return &ExpirationRecord{
-		Target:       r[0],
+		Target:       values["target"],
+		Kind:         values["kind"],
 		Expires:      expires,
 		Duration:     duration,
+		DurationSpec: values["durationSpec"],
+		Until:        values["until"],
 		ResetOnTouch: resetOnTouch,
+		Tags:         tags,
+		Action:       values["action"],
+		ClaimedBy:    values["claimedBy"],
+		Created:      created,
+		LastTouched:  lastTouched,
+		LastRenewed:  lastRenewed,
+		Notified:     notified,
+		RenewCount:   renewCount,
+		Seq:          seq,
+		extra:        extra,
 	}, nil
 }
 
-func toRecord(e ExpirationRecord) []string {
+// Empty for the zero time
+func parseOptionalTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(dateTimeFormat, value)
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(dateTimeFormat)
+}
+
+func isCSVColumn(name string) bool {
+	for _, column := range csvColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+func toRecord(e ExpirationRecord) map[string]string {
 	reset := "yes"
 	if !e.ResetOnTouch {
 		reset = "no"
 	}
 	expires := e.Expires.Format(dateTimeFormat)
 
-	return []string{
-		e.Target,
-		string(expires),
-		e.Duration.String(),
-		reset,
+	values := map[string]string{
+		"target":       e.Target,
+		"kind":         e.Kind,
+		"expires":      string(expires),
+		"duration":     e.Duration.String(),
+		"durationSpec": e.DurationSpec,
+		"until":        e.Until,
+		"resetOnTouch": reset,
+		"tags":         strings.Join(e.Tags, ","),
+		"action":       e.Action,
+		"claimedBy":    e.ClaimedBy,
+		"created":      formatOptionalTime(e.Created),
+		"lastTouched":  formatOptionalTime(e.LastTouched),
+		"lastRenewed":  formatOptionalTime(e.LastRenewed),
+		"notified":     formatOptionalTime(e.Notified),
+		"renewCount":   strconv.Itoa(e.RenewCount),
+		"seq":          strconv.Itoa(e.Seq),
+	}
+	for name, value := range e.extra {
+		values[name] = value
 	}
+	return values
 }
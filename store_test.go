@@ -0,0 +1,283 @@
+package expire
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const counterTarget = "key:counter"
+
+// Creates an expirations file holding a single counter record
+func newCounterStore(t *testing.T, format string) *fileStore {
+	t.Helper()
+	expirationsPath := filepath.Join(t.TempDir(), defaultFileName)
+	rec := &ExpirationRecord{
+		Target:   counterTarget,
+		Kind:     KindKey,
+		Expires:  time.Now().Add(time.Hour).Truncate(time.Second),
+		Duration: time.Hour,
+	}
+	err := writeRecordsToFile(expirationsPath, format, ExpirationRecords{rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFileStore(expirationsPath, GlobalConfig{})
+}
+
+func isCounter(rec ExpirationRecord) bool {
+	return rec.Target == counterTarget
+}
+
+// Increments the counter in a read-modify-write transaction
+func incrementCounter(store Store) error {
+	return store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		if !records.updateFirst(isCounter, func(rec *ExpirationRecord) {
+			rec.RenewCount++
+		}) {
+			return false, fmt.Errorf("No counter in %s", store.Path())
+		}
+		return true, nil
+	})
+}
+
+func readCounter(t *testing.T, store Store) int {
+	t.Helper()
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := records.getFirst(isCounter)
+	if !ok {
+		t.Fatalf("No counter in %s", store.Path())
+	}
+	return rec.RenewCount
+}
+
+func TestFileStoreConcurrentTransactions(t *testing.T) {
+	const workers = 8
+	const increments = 25
+
+	for _, format := range []string{FormatCSV, FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			store := newCounterStore(t, format)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, workers)
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// A store per goroutine, as separate processes would have
+					s := newFileStore(store.Path(), GlobalConfig{})
+					for j := 0; j < increments; j++ {
+						err := incrementCounter(s)
+						if err != nil {
+							errs <- err
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			if n := readCounter(t, store); n != workers*increments {
+				t.Errorf("Expected %d increments, got %d", workers*increments, n)
+			}
+		})
+	}
+}
+
+// Run by TestFileStoreConcurrentProcesses in a subprocess
+func TestHelperProcessIncrement(t *testing.T) {
+	expirationsPath := os.Getenv("EXPIRE_TEST_STORE")
+	if expirationsPath == "" {
+		t.Skip("Only run as a subprocess")
+	}
+	increments, err := strconv.Atoi(os.Getenv("EXPIRE_TEST_INCREMENTS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newFileStore(expirationsPath, GlobalConfig{})
+	for i := 0; i < increments; i++ {
+		err := incrementCounter(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileStoreConcurrentProcesses(t *testing.T) {
+	const processes = 4
+	const increments = 25
+
+	store := newCounterStore(t, FormatCSV)
+
+	cmds := make([]*exec.Cmd, 0, processes)
+	outputs := make([]*strings.Builder, 0, processes)
+	for i := 0; i < processes; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcessIncrement$")
+		cmd.Env = append(os.Environ(),
+			"EXPIRE_TEST_STORE="+store.Path(),
+			"EXPIRE_TEST_INCREMENTS="+strconv.Itoa(increments),
+		)
+		output := &strings.Builder{}
+		cmd.Stdout = output
+		cmd.Stderr = output
+		err := cmd.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+		outputs = append(outputs, output)
+	}
+	for i, cmd := range cmds {
+		err := cmd.Wait()
+		if err != nil {
+			t.Errorf("Subprocess failed: %s\n%s", err, outputs[i])
+		}
+	}
+
+	if n := readCounter(t, store); n != processes*increments {
+		t.Errorf("Expected %d increments, got %d", processes*increments, n)
+	}
+}
+
+func TestFileStoreLockTimeout(t *testing.T) {
+	store := newCounterStore(t, FormatCSV)
+	store.lockTimeout = 50 * time.Millisecond
+
+	unlock, err := acquireLock(store.Path(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = incrementCounter(store)
+	if err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Errorf("Expected a lock timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < store.lockTimeout {
+		t.Errorf("Gave up after %s, before the timeout of %s", elapsed, store.lockTimeout)
+	}
+
+	err = unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = incrementCounter(store)
+	if err != nil {
+		t.Fatalf("Failed after the lock was released: %s", err)
+	}
+	if n := readCounter(t, store); n != 1 {
+		t.Errorf("Expected 1 increment, got %d", n)
+	}
+}
+
+func TestFileStoreRemoveKeepsLock(t *testing.T) {
+	store := newCounterStore(t, FormatCSV)
+	err := store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		return false, store.Remove()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(store.Path()) {
+		t.Errorf("%s was not removed", store.Path())
+	}
+	if !exists(getLockFilePath(store.Path())) {
+		t.Errorf("The lock file was removed with %s", store.Path())
+	}
+}
+
+func TestMemoryStoreConcurrentTransactions(t *testing.T) {
+	const workers = 8
+	const increments = 25
+
+	store := NewMemoryStore(defaultFileName, ExpirationRecords{{
+		Target:   counterTarget,
+		Kind:     KindKey,
+		Expires:  time.Now().Add(time.Hour),
+		Duration: time.Hour,
+	}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				err := incrementCounter(store)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := readCounter(t, store); n != workers*increments {
+		t.Errorf("Expected %d increments, got %d", workers*increments, n)
+	}
+}
+
+func TestWriteRecordsToFileKeepsSymlink(t *testing.T) {
+	const workers = 4
+	const increments = 25
+
+	store := newCounterStore(t, FormatCSV)
+	link := filepath.Join(t.TempDir(), defaultFileName)
+	err := os.Symlink(store.Path(), link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Half of the writers go through the link, half through the target
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		path := store.Path()
+		if i%2 == 0 {
+			path = link
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := newFileStore(path, GlobalConfig{})
+			for j := 0; j < increments; j++ {
+				err := incrementCounter(s)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s was replaced with a regular file", link)
+	}
+	if n := readCounter(t, store); n != workers*increments {
+		t.Errorf("Expected %d increments through the link and the target, got %d", workers*increments, n)
+	}
+}
@@ -0,0 +1,68 @@
+package expire
+
+import (
+	"errors"
+)
+
+type TagConfig struct {
+	GlobalConfig
+	BatchRunConfig
+	DryRunConfig
+	TargetConfig
+	Tags []string
+}
+
+func checkTag(config *TagConfig) error {
+	if len(config.Tags) == 0 {
+		return errors.New("No tags")
+	}
+	return checkTags(config.Tags)
+}
+
+// Adds tags to a record, if it doesn't have them already
+func AddTags(config *TagConfig) error {
+	err := checkTag(config)
+	if err != nil {
+		return err
+	}
+
+	return Update(&UpdateConfig{
+		config.GlobalConfig,
+		config.BatchRunConfig,
+		config.DryRunConfig,
+		config.TargetConfig,
+		"",
+	}, func(rec *ExpirationRecord) {
+		tags := append([]string{}, rec.Tags...)
+		for _, tag := range config.Tags {
+			if !containsString(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		rec.Tags = tags
+	})
+}
+
+// Removes tags from a record
+func RemoveTags(config *TagConfig) error {
+	err := checkTag(config)
+	if err != nil {
+		return err
+	}
+
+	return Update(&UpdateConfig{
+		config.GlobalConfig,
+		config.BatchRunConfig,
+		config.DryRunConfig,
+		config.TargetConfig,
+		"",
+	}, func(rec *ExpirationRecord) {
+		tags := make([]string, 0, len(rec.Tags))
+		for _, tag := range rec.Tags {
+			if !containsString(config.Tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		rec.Tags = tags
+	})
+}
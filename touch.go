@@ -12,16 +12,24 @@ type TouchConfig struct {
 }
 
 func Touch(config *TouchConfig) error {
+	cal, err := loadCalendar(config.GlobalConfig)
+	if err != nil {
+		return err
+	}
+
 	return Update(&UpdateConfig{
 		config.GlobalConfig,
 		config.BatchRunConfig,
 		config.DryRunConfig,
 		config.TargetConfig,
+		"touch",
 	}, func(rec *ExpirationRecord) {
 		// touch this record: i.e. if it has not expired, reset the timer
-		if rec.ResetOnTouch && rec.Expires.After(time.Now()) {
-			rec.Expires = time.Now().Add(rec.Duration)
+		now := time.Now()
+		if rec.ResetOnTouch && rec.Expires.After(now) {
+			rec.Expires = rec.nextExpiration(now, cal)
 		}
+		rec.LastTouched = now
 	})
 
 }
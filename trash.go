@@ -0,0 +1,266 @@
+package expire
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trashing follows the freedesktop.org trash specification: a trash
+// directory has a files directory holding the trashed files and an info
+// directory holding a .trashinfo file for each, e.g.
+//
+//	[Trash Info]
+//	Path=/home/me/notes/a.txt
+//	DeletionDate=2026-01-02T15:04:05
+//	X-Expire-Target=a.txt
+//	X-Expire-File=/home/me/notes/.expirations
+//	X-Expire-Record={"target":"a.txt",...}
+//
+// The X-Expire keys are ignored by other trash implementations, and let
+// restore put the record back.
+
+const trashInfoHeader = "[Trash Info]"
+const trashInfoExt = ".trashinfo"
+const trashDateFormat = "2006-01-02T15:04:05"
+
+// The trash in the user's home: $XDG_DATA_HOME/Trash
+func homeTrashDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "Trash"), nil
+}
+
+// The device of the path, or of its nearest existing ancestor
+func deviceOf(absPath string) (uint64, error) {
+	for {
+		dev, err := fileDevice(absPath)
+		if err == nil {
+			return dev, nil
+		}
+		parent := filepath.Dir(absPath)
+		if !os.IsNotExist(err) || parent == absPath {
+			return 0, err
+		}
+		absPath = parent
+	}
+}
+
+// The top directory of the mount the path is on
+func mountTopDir(absPath string, dev uint64) string {
+	dir := filepath.Dir(absPath)
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		parentDev, err := deviceOf(parent)
+		if err != nil || parentDev != dev {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// The trash directories of a mount's top directory which belong to the
+// user: $topdir/.Trash/$uid if $topdir/.Trash is a sticky directory, and
+// $topdir/.Trash-$uid
+func topDirTrashDirs(topDir string) []string {
+	uid := strconv.Itoa(os.Getuid())
+	dirs := make([]string, 0, 2)
+	shared := filepath.Join(topDir, ".Trash")
+	info, err := os.Lstat(shared)
+	if err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		dirs = append(dirs, filepath.Join(shared, uid))
+	}
+	return append(dirs, filepath.Join(topDir, ".Trash-"+uid))
+}
+
+// The trash directories a file could have been trashed to, the one it
+// would be trashed to now first
+func trashDirsFor(absPath string) ([]string, error) {
+	homeTrash, err := homeTrashDir()
+	if err != nil {
+		return nil, err
+	}
+	homeDev, err := deviceOf(homeTrash)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := deviceOf(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if dev == homeDev {
+		return []string{homeTrash}, nil
+	}
+	return append(topDirTrashDirs(mountTopDir(absPath, dev)), homeTrash), nil
+}
+
+// Moves the target of the record to the trash, recording the record in the
+// trashinfo so it can be restored. A missing target is not an error.
+// The record must have been resolved against the repo, see resolveTarget.
+func trashTarget(rec *ExpirationRecord, expirationsPath string) error {
+	if rec.IsKey() || !exists(rec.targetFilePathAbs) {
+		return nil
+	}
+
+	trashDirs, err := trashDirsFor(rec.targetFilePathAbs)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, trashDir := range trashDirs {
+		lastErr = trashTo(trashDir, rec, expirationsPath)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Failed to trash %s: %s", rec.targetFilePathAbs, lastErr)
+}
+
+func trashTo(trashDir string, rec *ExpirationRecord, expirationsPath string) error {
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	absExpirationsPath, err := filepath.Abs(expirationsPath)
+	if err != nil {
+		return err
+	}
+	recordJSON, err := json.Marshal(toJSONLRecord(*rec))
+	if err != nil {
+		return err
+	}
+	content := strings.Join([]string{
+		trashInfoHeader,
+		"Path=" + (&url.URL{Path: rec.targetFilePathAbs}).EscapedPath(),
+		"DeletionDate=" + time.Now().Format(trashDateFormat),
+		"X-Expire-Target=" + rec.Target,
+		"X-Expire-File=" + absExpirationsPath,
+		"X-Expire-Record=" + string(recordJSON),
+	}, "\n") + "\n"
+
+	// The info file is created exclusively to claim the name in the trash
+	base := filepath.Base(rec.targetFilePathAbs)
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s.%d", base, i)
+		}
+		infoPath := filepath.Join(infoDir, name+trashInfoExt)
+		f, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(content)
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(rec.targetFilePathAbs, filepath.Join(filesDir, name))
+		}
+		if err != nil {
+			os.Remove(infoPath)
+		}
+		return err
+	}
+}
+
+// A file in the trash
+type trashEntry struct {
+	infoPath  string
+	filesPath string
+	info      map[string]string
+}
+
+func (e trashEntry) originalPath() (string, error) {
+	return url.PathUnescape(e.info["Path"])
+}
+
+func readTrashInfo(infoPath string) (map[string]string, error) {
+	f, err := os.Open(infoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := make(map[string]string)
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inSection = line == trashInfoHeader
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			info[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return info, scanner.Err()
+}
+
+// The entries trashed from the repo for the target, most recently trashed first
+func findTrashed(trashDirs []string, absExpirationsPath string, target string) ([]trashEntry, error) {
+	entries := make([]trashEntry, 0)
+	for _, trashDir := range trashDirs {
+		infoDir := filepath.Join(trashDir, "info")
+		infos, err := ioutil.ReadDir(infoDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range infos {
+			if !strings.HasSuffix(fi.Name(), trashInfoExt) {
+				continue
+			}
+			infoPath := filepath.Join(infoDir, fi.Name())
+			info, err := readTrashInfo(infoPath)
+			if err != nil {
+				return nil, err
+			}
+			if info["X-Expire-File"] != absExpirationsPath || info["X-Expire-Target"] != target {
+				continue
+			}
+			entries = append(entries, trashEntry{
+				infoPath:  infoPath,
+				filesPath: filepath.Join(trashDir, "files", strings.TrimSuffix(fi.Name(), trashInfoExt)),
+				info:      info,
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].info["DeletionDate"] > entries[j].info["DeletionDate"]
+	})
+	return entries, nil
+}
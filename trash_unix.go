@@ -0,0 +1,17 @@
+//go:build unix
+
+package expire
+
+import (
+	"syscall"
+)
+
+// The device the file is on, without following symlinks
+func fileDevice(path string) (uint64, error) {
+	var st syscall.Stat_t
+	err := syscall.Lstat(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Dev), nil
+}
@@ -7,15 +7,21 @@ type UpdateConfig struct {
 	BatchRunConfig
 	DryRunConfig
 	TargetConfig
+	// The operation, for hooks: "touch" runs the pre-touch and post-touch
+	// hooks. Empty runs none.
+	Event string
 }
 
+// Applies the action to the record of every target, in a single transaction.
+// Targets without a record are reported in the error (unless batch run),
+// the rest are still updated.
 func Update(config *UpdateConfig, action func(*ExpirationRecord)) error {
-	if config.Target == "" {
+	if len(config.getTargets()) == 0 {
 		return errors.New("No target")
 	}
 
-	expirationsPath := getExpirationsFilePath(config.GlobalConfig)
-	if expirationsPath == "" {
+	store := findStore(config.GlobalConfig)
+	if store == nil {
 		if config.IsBatchRun {
 			// TODO consider maybe throwing an error anyway in the case of a global type error
 			return nil
@@ -24,30 +30,54 @@ func Update(config *UpdateConfig, action func(*ExpirationRecord)) error {
 		}
 	}
 
-	records, err := readRecordsFromFile(expirationsPath)
+	targets, err := config.canonicalTargets(store.Path())
 	if err != nil {
 		return err
 	}
 
-	ok := records.updateFirst(func(rec ExpirationRecord) bool {
-		return rec.Target == config.Target
-	}, action)
+	var errs []error
+	var updated []ExpirationRecord
+	err = store.Transaction(func(records *ExpirationRecords) (bool, error) {
+		for _, target := range targets {
+			isTarget := func(rec ExpirationRecord) bool {
+				return rec.Target == target
+			}
+			rec, ok := records.getFirst(isTarget)
 
-	if !ok {
-		if config.IsDryRun {
-			dryRunReporter.ReportAction("Will not touch non-existent record: %s", config.Target)
-		}
-		if config.IsBatchRun {
-			return nil
-		} else {
-			return errors.New("No such record: " + config.Target)
+			if !ok {
+				if config.IsDryRun {
+					dryRunReporter.ReportAction("Will not touch non-existent record: %s", target)
+				}
+				if !config.IsBatchRun {
+					errs = append(errs, errors.New("No such record: "+target))
+				}
+				continue
+			}
+
+			if config.IsDryRun {
+				dryRunReporter.ReportAction("Will touch record: %s", target)
+				continue
+			}
+
+			if config.Event != "" {
+				_, err := runHooks(config.GlobalConfig, "pre-"+config.Event, rec, store.Path())
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			records.updateFirst(isTarget, action)
+			rec, _ = records.getFirst(isTarget)
+			updated = append(updated, rec)
 		}
-	}
 
-	if config.IsDryRun {
-		dryRunReporter.ReportAction("Will touch record: %s", config.Target)
-		return nil
+		return len(updated) > 0, nil
+	})
+	if err != nil {
+		return err
 	}
-
-	return writeRecordsToFile(expirationsPath, records)
+	if config.Event != "" {
+		runPostHooks(config.GlobalConfig, "post-"+config.Event, updated, store.Path())
+	}
+	return targetErrors(errs)
 }